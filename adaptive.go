@@ -0,0 +1,97 @@
+package retrydb
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minAdaptiveQueryTime is the lowest slow query threshold SetAdaptiveQueryTime
+// sets, so a pause such as a GC on a very fast workload does not fail over
+const minAdaptiveQueryTime = 10 * time.Millisecond
+
+// latencyWindow is a ring buffer of the most recent Primary query latencies
+// with its 95th percentile kept up to date as samples are added
+type latencyWindow struct {
+	sync.Mutex
+	samples []time.Duration
+	sorted  []time.Duration // samples in ascending order
+	next    int
+	p95     atomic.Int64 // zero until the window is full
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, 0, size)}
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.samples) < cap(w.samples) {
+		w.samples = append(w.samples, d)
+		w.sorted = append(w.sorted, 0)
+	} else {
+		// drop the oldest sample from sorted
+		old := w.samples[w.next]
+		i := sort.Search(len(w.sorted), func(i int) bool { return w.sorted[i] >= old })
+		copy(w.sorted[i:], w.sorted[i+1:])
+		w.samples[w.next] = d
+	}
+	w.next = (w.next + 1) % cap(w.samples)
+	// insert d into the free slot at the end of sorted
+	n := len(w.sorted) - 1
+	i := sort.Search(n, func(i int) bool { return w.sorted[i] >= d })
+	copy(w.sorted[i+1:], w.sorted[i:n])
+	w.sorted[i] = d
+	if len(w.samples) == cap(w.samples) {
+		w.p95.Store(int64(w.sorted[int(math.Ceil(0.95*float64(n+1)))-1]))
+	}
+}
+
+// SetAdaptiveQueryTime replaces the static SetMaxQueryTime threshold with
+// multiplier times the 95th percentile latency of the last window Primary
+// queries, so slow query failover adapts to the workload. The threshold is at
+// least 10ms and at most the max query time (including one set with
+// WithMaxQueryTime), which also applies alone until window queries have been
+// seen. A window of 0 disables it.
+func (db *RetryDB) SetAdaptiveQueryTime(multiplier float64, window int) {
+	db.Lock()
+	db.adaptiveMultiplier = multiplier
+	db.latencies = nil
+	if window > 0 && multiplier > 0 {
+		db.latencies = newLatencyWindow(window)
+	}
+	db.Unlock()
+}
+
+// observeLatency records a Primary query latency for SetAdaptiveQueryTime
+func (db *RetryDB) observeLatency(d time.Duration) {
+	db.RLock()
+	w := db.latencies
+	db.RUnlock()
+	if w != nil {
+		w.add(d)
+	}
+}
+
+// adaptiveQueryTime returns the adaptive slow query threshold; ok is false
+// when it is disabled or the window is not yet full
+func (db *RetryDB) adaptiveQueryTime() (d time.Duration, ok bool) {
+	db.RLock()
+	w, multiplier := db.latencies, db.adaptiveMultiplier
+	db.RUnlock()
+	if w == nil {
+		return 0, false
+	}
+	p95 := time.Duration(w.p95.Load())
+	if p95 == 0 {
+		return 0, false
+	}
+	d = time.Duration(multiplier * float64(p95))
+	if d < minAdaptiveQueryTime {
+		d = minAdaptiveQueryTime
+	}
+	return d, true
+}
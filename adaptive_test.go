@@ -0,0 +1,67 @@
+package retrydb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveQueryTime(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	db.SetAdaptiveQueryTime(2, 20)
+	for i := 1; i <= 19; i++ {
+		db.observeLatency(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := db.adaptiveQueryTime(); ok {
+		t.Fatal("expected no adaptive threshold before the window is full")
+	}
+	if d := db.queryTimeLimit(context.Background(), time.Now()); d != db.maxQueryTime {
+		t.Fatalf("got limit %s expected max query time %s", d, db.maxQueryTime)
+	}
+
+	db.observeLatency(20 * time.Millisecond)
+	if d, ok := db.adaptiveQueryTime(); !ok || d != 38*time.Millisecond {
+		t.Fatalf("got adaptive threshold %s expected 38ms", d)
+	}
+	// the max query time caps the threshold
+	err := db.WithMaxQueryTime(30*time.Millisecond, func() error {
+		if d := db.queryTimeLimit(context.Background(), time.Now()); d != 30*time.Millisecond {
+			t.Fatalf("got limit %s expected 30ms", d)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the window rolls over; the oldest samples are replaced
+	for i := 0; i < 18; i++ {
+		db.observeLatency(5 * time.Millisecond)
+	}
+	if d, _ := db.adaptiveQueryTime(); d != 38*time.Millisecond {
+		t.Fatalf("got adaptive threshold %s expected 38ms", d)
+	}
+	db.observeLatency(5 * time.Millisecond)
+	if d, _ := db.adaptiveQueryTime(); d != 10*time.Millisecond {
+		t.Fatalf("got adaptive threshold %s expected 10ms", d)
+	}
+	// very fast queries do not lower the threshold below the minimum
+	for i := 0; i < 20; i++ {
+		db.observeLatency(100 * time.Microsecond)
+	}
+	if d := db.queryTimeLimit(context.Background(), time.Now()); d != minAdaptiveQueryTime {
+		t.Fatalf("got limit %s expected %s", d, minAdaptiveQueryTime)
+	}
+
+	// a query well above the adaptive threshold fails over
+	p.setDelay(20 * time.Millisecond)
+	queryValue(t, db, "select 1")
+	if n := db.Stats().RetryCount; n != 1 {
+		t.Fatalf("got retry count %d expected 1", n)
+	}
+
+	db.SetAdaptiveQueryTime(0, 0)
+	if _, ok := db.adaptiveQueryTime(); ok {
+		t.Fatal("expected adaptive threshold disabled")
+	}
+}
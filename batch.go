@@ -0,0 +1,168 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Query is a single statement in a BatchQuery
+type Query struct {
+	SQL  string
+	Args []interface{}
+}
+
+// BatchQuery runs all queries against the same endpoint. The endpoint is
+// chosen once for the batch the way Query chooses it, honoring ForcePrimary,
+// the read preference, PinSecondary and the failover window. If any query
+// fails against the Primary with an error that fails over (see
+// SetPrimaryErrorClassifier and SetFailoverDecider) the whole batch is run
+// against the Secondary.
+//
+// Unlike Query a batch is not hedged, routed by SetHintRouting or
+// WithConsistency, checked against the max query time or reported to
+// OnQuery.
+//
+// On error any Rows already opened are closed.
+func (db *RetryDB) BatchQuery(ctx context.Context, queries []Query) ([]*sql.Rows, error) {
+	for _, q := range queries {
+		if err := db.validateArgs(q.SQL, q.Args); err != nil {
+			return nil, err
+		}
+	}
+	if isForcePrimary(ctx) {
+		results, _, err := db.batch(ctx, SourcePrimary, queries)
+		return results, err
+	}
+	if !db.secondaryActive() {
+		return db.primaryOnlyBatch(ctx, queries)
+	}
+	db.RLock()
+	pref := db.readPreference
+	db.RUnlock()
+	switch pref {
+	case PrimaryOnly:
+		results, _, err := db.batch(ctx, SourcePrimary, queries)
+		return results, err
+	case SecondaryOnly:
+		results, _, err := db.batch(ctx, SourceSecondary, queries)
+		return results, err
+	case SecondaryPreferred:
+		return db.secondaryPreferredBatch(ctx, queries)
+	}
+
+	start := time.Now()
+	if db.pinned(start) {
+		results, _, err := db.batch(ctx, SourceSecondary, queries)
+		return results, err
+	}
+	if db.retrying(start) || db.probeAsync(start) || db.recovering() {
+		if db.secondaryOpen(start) {
+			return nil, ErrNoHealthyEndpoint
+		}
+		atomic.AddUint32(&db.secondaryQueries, uint32(len(queries)))
+		results, _, err := db.batch(ctx, SourceSecondary, queries)
+		return results, err
+	}
+
+	probe := db.primaryDisabled()
+	results, failed, err := db.batch(ctx, SourcePrimary, queries)
+	if err != nil && !failed {
+		return nil, err
+	}
+	failover := db.recordPrimaryOutcome(failed)
+	if !failed {
+		db.probed(probe, nil)
+		if db.primaryDisabled() {
+			db.primaryRecovered(ctx)
+		}
+		return results, nil
+	}
+	db.probed(probe, err)
+	if failover {
+		db.updateRetry(ctx, ReasonQueryError, fmt.Errorf("batch query errored with %s. retrying batch against secondary", err))
+	}
+	if db.secondaryOpen(time.Now()) {
+		return nil, errors.Join(err, ErrNoHealthyEndpoint)
+	}
+	results, _, serr := db.batch(ctx, SourceSecondary, queries)
+	if serr != nil {
+		// keep both errors inspectable with errors.Is and errors.As
+		return nil, errors.Join(err, serr)
+	}
+	return results, nil
+}
+
+// primaryOnlyBatch is primaryOnlyQuery for a batch
+func (db *RetryDB) primaryOnlyBatch(ctx context.Context, queries []Query) ([]*sql.Rows, error) {
+	db.RLock()
+	backoff := db.noSecondaryRetry != nil
+	db.RUnlock()
+	if !backoff {
+		results, _, err := db.batch(ctx, SourcePrimary, queries)
+		return results, err
+	}
+	if db.retrying(time.Now()) {
+		return nil, ErrPrimaryBackoff
+	}
+	results, failed, err := db.batch(ctx, SourcePrimary, queries)
+	if failed {
+		db.updateRetry(ctx, ReasonQueryError, fmt.Errorf("batch query errored with %s. no secondary configured", err))
+	} else if err == nil && db.primaryDisabled() {
+		db.updateRetry(ctx, ReasonNone, nil)
+	}
+	return results, err
+}
+
+// secondaryPreferredBatch is secondaryPreferredQuery for a batch
+func (db *RetryDB) secondaryPreferredBatch(ctx context.Context, queries []Query) ([]*sql.Rows, error) {
+	if !db.secondaryOpen(time.Now()) {
+		results, failed, err := db.batch(ctx, SourceSecondary, queries)
+		if !failed {
+			return results, err
+		}
+	}
+	results, _, err := db.batch(ctx, SourcePrimary, queries)
+	return results, err
+}
+
+// batch runs queries against source, stopping at the first one that errors
+// and closing any Rows already opened. failed reports whether the error
+// counts as a failure of source, using the same classification as a single
+// query; other errors, such as a context error from the caller, are only
+// returned.
+func (db *RetryDB) batch(ctx context.Context, source Source, queries []Query) (results []*sql.Rows, failed bool, err error) {
+	results = make([]*sql.Rows, 0, len(queries))
+	for _, q := range queries {
+		start := time.Now()
+		var rows *sql.Rows
+		var qerr, ferr error
+		if source == SourcePrimary {
+			rows, qerr = db.primaryQueryRetry(ctx, q.SQL, q.Args...)
+			ferr, _ = db.primaryError(ctx, qerr, rows, q.SQL, time.Since(start))
+		} else {
+			rows, _, qerr = db.secondaryQueryRetry(ctx, q.SQL, q.Args...)
+			if ferr = db.fatalError(source, qerr, rows); callerContextError(ctx, ferr) {
+				ferr = nil
+			}
+		}
+		if qerr == nil && ferr == nil {
+			results = append(results, rows)
+			continue
+		}
+		if rows != nil {
+			rows.Close()
+		}
+		for _, r := range results {
+			r.Close()
+		}
+		if ferr == nil {
+			return nil, false, fmt.Errorf("%w sql:%q", qerr, q.SQL)
+		}
+		return nil, true, fmt.Errorf("%w sql:%q", ferr, q.SQL)
+	}
+	return results, false, nil
+}
@@ -0,0 +1,115 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBatchQuery(t *testing.T) {
+	db, p, s := newTestDB(t)
+	ctx := context.Background()
+	batch := []Query{{SQL: "select 1"}, {SQL: "select 2"}}
+
+	check := func(expected string) {
+		t.Helper()
+		results, err := db.BatchQuery(ctx, batch)
+		for _, rows := range results {
+			if v := scanValue(t, rows, err); v != expected {
+				t.Fatalf("got %q expected %q", v, expected)
+			}
+		}
+		if len(results) != len(batch) {
+			t.Fatalf("got %d results expected %d", len(results), len(batch))
+		}
+	}
+	check(p.name)
+
+	// the second statement fails on the primary; the whole batch moves to the secondary
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if query == "select 2" {
+			return nil, nil, errTest
+		}
+		return []string{"source"}, [][]driver.Value{{p.name}}, nil
+	})
+	check(s.name)
+	if n := s.queryCount(); n != 2 {
+		t.Fatalf("got %d secondary queries expected 2", n)
+	}
+
+	// while the primary is disabled the batch goes straight to the secondary
+	p.setHandler(nil)
+	check(s.name)
+	if n := p.queryCount(); n != 4 {
+		t.Fatalf("got %d primary queries expected 4", n)
+	}
+}
+
+func TestBatchQueryRouting(t *testing.T) {
+	db, p, s := newTestDB(t)
+	ctx := context.Background()
+	batch := []Query{{SQL: "select 1"}, {SQL: "select 2"}}
+
+	// args are validated before either endpoint is queried
+	db.SetPlaceholderValidation(QuestionPlaceholders)
+	if _, err := db.BatchQuery(ctx, []Query{{SQL: "select ?"}}); !errors.Is(err, ErrArgCountMismatch) {
+		t.Fatalf("got %v expected %v", err, ErrArgCountMismatch)
+	}
+
+	// errors the classifier doesn't consider fatal are returned without failover
+	db.SetPrimaryErrorClassifier(func(error) bool { return false })
+	p.setErr(errTest)
+	if _, err := db.BatchQuery(ctx, batch); !errors.Is(err, errTest) {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if n := s.queryCount(); n != 0 || db.Stats().RetryCount != 0 {
+		t.Fatalf("got %d secondary queries, retry count %d", n, db.Stats().RetryCount)
+	}
+
+	// when both endpoints fail both errors are returned
+	db.SetPrimaryErrorClassifier(nil)
+	errSecondary := errors.New("secondary error")
+	s.setErr(errSecondary)
+	_, err := db.BatchQuery(ctx, batch)
+	if !errors.Is(err, errTest) || !errors.Is(err, errSecondary) {
+		t.Fatalf("got %v expected both errors", err)
+	}
+
+	// ForcePrimary skips the failover window
+	p.setErr(nil)
+	results, err := db.BatchQuery(ForcePrimary(ctx), batch)
+	if v := scanValue(t, results[0], err); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	results[1].Close()
+
+	// and the read preference is honored
+	s.setErr(nil)
+	db.ResetCircuit(SourcePrimary)
+	db.SetReadPreference(SecondaryOnly)
+	results, err = db.BatchQuery(ctx, batch)
+	if v := scanValue(t, results[0], err); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	results[1].Close()
+}
+
+func TestBatchQueryFailFast(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetFailFast(time.Minute)
+	p.setErr(errTest)
+	s.setErr(errTest)
+	batch := []Query{{SQL: "select 1"}}
+
+	if _, err := db.BatchQuery(context.Background(), batch); !errors.Is(err, errTest) {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if _, err := db.BatchQuery(context.Background(), batch); err != ErrNoHealthyEndpoint {
+		t.Fatalf("got %v expected %v", err, ErrNoHealthyEndpoint)
+	}
+	if p.queryCount() != 1 || s.queryCount() != 1 {
+		t.Fatalf("got %d primary %d secondary queries", p.queryCount(), s.queryCount())
+	}
+}
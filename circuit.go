@@ -0,0 +1,69 @@
+package retrydb
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNoHealthyEndpoint is returned without querying either endpoint when both
+// the Primary and Secondary are known to be down. See SetFailFast.
+var ErrNoHealthyEndpoint = errors.New("retrydb: no healthy endpoint")
+
+// SetFailFast enables per-endpoint circuit breaking. When a Secondary query
+// fails its circuit opens for openFor; while both it and the Primary failover
+// window are open, queries fail immediately with ErrNoHealthyEndpoint, as
+// does a failed Primary query while the Secondary circuit is open. Once
+// openFor elapses the next query tries the Secondary again. Zero (the
+// default) disables fail fast.
+func (db *RetryDB) SetFailFast(openFor time.Duration) {
+	db.Lock()
+	db.secondaryOpenFor = openFor
+	db.Unlock()
+}
+
+// secondaryOpen returns true if the Secondary circuit is open at time t
+func (db *RetryDB) secondaryOpen(t time.Time) bool {
+	db.RLock()
+	until := db.secondaryOpenUntil
+	db.RUnlock()
+	return t.Before(until)
+}
+
+// recordSecondaryOutcome opens the Secondary circuit on failure and closes it
+// on success
+func (db *RetryDB) recordSecondaryOutcome(failed bool) {
+	db.RLock()
+	openFor, open := db.secondaryOpenFor, !db.secondaryOpenUntil.IsZero()
+	db.RUnlock()
+	if openFor == 0 || (!failed && !open) {
+		return
+	}
+	db.Lock()
+	if failed {
+		db.secondaryOpenUntil = time.Now().Add(openFor)
+	} else {
+		db.secondaryOpenUntil = time.Time{}
+	}
+	db.Unlock()
+}
+
+// ResetCircuit closes the circuit of one endpoint after remediation without
+// affecting the other. For SourcePrimary it ends the failover window and
+// clears the retry count and recent error rate outcomes; for SourceSecondary
+// it closes the SetFailFast circuit.
+func (db *RetryDB) ResetCircuit(source Source) {
+	switch source {
+	case SourcePrimary:
+		db.Lock()
+		db.outcomes = outcomeRing{}
+		db.Unlock()
+		if db.primaryDisabled() {
+			db.updateRetry(context.Background(), ReasonNone, nil)
+		}
+	case SourceSecondary:
+		db.Lock()
+		db.secondaryOpenUntil = time.Time{}
+		db.Unlock()
+	}
+}
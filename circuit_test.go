@@ -0,0 +1,113 @@
+package retrydb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailFast(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetFailFast(20 * time.Millisecond)
+	p.setErr(errTest)
+	s.setErr(errTest)
+
+	if _, err := db.Query("select 1"); !errors.Is(err, errTest) {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.Query("select 1"); err != ErrNoHealthyEndpoint {
+			t.Fatalf("got %v expected %v", err, ErrNoHealthyEndpoint)
+		}
+	}
+	if p.queryCount() != 1 || s.queryCount() != 1 {
+		t.Fatalf("got %d primary %d secondary queries", p.queryCount(), s.queryCount())
+	}
+
+	// after the probe interval the secondary is tried again
+	time.Sleep(20 * time.Millisecond)
+	s.setErr(nil)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	queryValue(t, db, "select 1")
+	if n := s.queryCount(); n != 3 {
+		t.Fatalf("got %d secondary queries expected 3", n)
+	}
+}
+
+func TestFailFastWindowExpired(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetRetryStrategy(func(uint32) time.Duration { return 10 * time.Millisecond })
+	db.SetFailFast(time.Minute)
+	p.setErr(errTest)
+	s.setErr(errTest)
+	db.Query("select 1")
+
+	// the primary probe after the window expires fails, and the secondary
+	// circuit is still open
+	time.Sleep(10 * time.Millisecond)
+	_, err := db.Query("select 1")
+	if !errors.Is(err, ErrNoHealthyEndpoint) || !errors.Is(err, errTest) {
+		t.Fatalf("got %v expected %v and %v", err, ErrNoHealthyEndpoint, errTest)
+	}
+	if p.queryCount() != 2 || s.queryCount() != 1 {
+		t.Fatalf("got %d primary %d secondary queries", p.queryCount(), s.queryCount())
+	}
+}
+
+func TestResetCircuit(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetFailFast(time.Minute)
+	openBoth := func() {
+		t.Helper()
+		p.setErr(errTest)
+		s.setErr(errTest)
+		db.Query("select 1")
+		if _, err := db.Query("select 1"); err != ErrNoHealthyEndpoint {
+			t.Fatalf("got %v expected %v", err, ErrNoHealthyEndpoint)
+		}
+		p.setErr(nil)
+		s.setErr(nil)
+	}
+
+	openBoth()
+	db.ResetCircuit(SourceSecondary)
+	primaryQueries := p.queryCount()
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := p.queryCount(); n != primaryQueries {
+		t.Fatal("expected the primary to stay disabled")
+	}
+
+	openBoth()
+	db.ResetCircuit(SourcePrimary)
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if !db.secondaryOpen(time.Now()) {
+		t.Fatal("expected the secondary circuit to stay open")
+	}
+}
+
+func TestResetCircuitEnabledPrimary(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	events := db.FailoverEvents()
+
+	// resetting a primary that was never disabled is a no-op
+	db.ResetCircuit(SourcePrimary)
+	select {
+	case e := <-events:
+		t.Fatalf("got %+v expected no event", e)
+	default:
+	}
+
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	<-events
+	db.ResetCircuit(SourcePrimary)
+	if e := <-events; e.Type != PrimaryEnabled {
+		t.Fatalf("got %+v expected %v", e, PrimaryEnabled)
+	}
+}
@@ -0,0 +1,60 @@
+package retrydb
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrSecondaryOverloaded is returned instead of querying the Secondary when
+// SetSecondaryMaxConcurrent queries are already running against it
+var ErrSecondaryOverloaded = errors.New("retrydb: secondary overloaded")
+
+// SetSecondaryMaxConcurrent limits the number of queries running against the
+// Secondary at once, so a smaller replica is not overwhelmed when all traffic
+// shifts to it. A query holds its slot until its Rows are closed (or, for a
+// Secondary without QueryContext, until they are returned). Queries beyond the
+// limit fail with ErrSecondaryOverloaded unless SetSecondaryOverloadWait is
+// enabled. Zero (the default) is unlimited.
+func (db *RetryDB) SetSecondaryMaxConcurrent(n int) {
+	db.Lock()
+	if n > 0 {
+		db.secondarySem = make(chan struct{}, n)
+	} else {
+		db.secondarySem = nil
+	}
+	db.Unlock()
+}
+
+// SetSecondaryOverloadWait makes queries beyond SetSecondaryMaxConcurrent wait
+// for a slot, bounded by their context, instead of failing fast
+func (db *RetryDB) SetSecondaryOverloadWait(wait bool) {
+	db.Lock()
+	db.secondaryWait = wait
+	db.Unlock()
+}
+
+// acquireSecondary takes a Secondary concurrency slot, returning a func that
+// releases it
+func (db *RetryDB) acquireSecondary(ctx context.Context) (func(), error) {
+	db.RLock()
+	sem, wait := db.secondarySem, db.secondaryWait
+	db.RUnlock()
+	if sem == nil {
+		return func() {}, nil
+	}
+	release := func() { <-sem }
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if !wait {
+		return nil, ErrSecondaryOverloaded
+	}
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSecondaryMaxConcurrent(t *testing.T) {
+	for _, wait := range []bool{false, true} {
+		db, p, s := newTestDB(t)
+		db.SetSecondaryMaxConcurrent(2)
+		db.SetSecondaryOverloadWait(wait)
+		var running, peak atomic.Int32
+		s.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			return []string{"source"}, [][]driver.Value{{"secondary"}}, nil
+		})
+		p.setErr(errTest)
+
+		var wg sync.WaitGroup
+		var overloaded atomic.Int32
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rows, err := db.Query("select 1")
+				if errors.Is(err, ErrSecondaryOverloaded) {
+					overloaded.Add(1)
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				rows.Close()
+			}()
+		}
+		wg.Wait()
+		if n := peak.Load(); n > 2 {
+			t.Fatalf("wait=%t got %d concurrent secondary queries", wait, n)
+		}
+		if n := overloaded.Load(); wait && n != 0 || !wait && n == 0 {
+			t.Fatalf("wait=%t got %d overloaded queries", wait, n)
+		}
+	}
+}
+
+func TestSecondarySlotHeldUntilClose(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetSecondaryMaxConcurrent(1)
+	p.setErr(errTest)
+
+	rows, err := db.Query("select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the open rows still hold the only slot
+	if _, err := db.Query("select 1"); !errors.Is(err, ErrSecondaryOverloaded) {
+		t.Fatalf("got %v expected %v", err, ErrSecondaryOverloaded)
+	}
+	if v := scanValue(t, rows, nil); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+
+	// a failed query releases its slot
+	s.setErr(errTest)
+	if _, err := db.Query("select 1"); err == nil || errors.Is(err, ErrSecondaryOverloaded) {
+		t.Fatalf("got %v expected the secondary error", err)
+	}
+	s.setErr(nil)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+}
@@ -0,0 +1,113 @@
+package retrydb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Config describes a RetryDB for use with NewFromConfig. In JSON MaxQueryTime
+// is a duration string such as "1.5s"; a number of nanoseconds is also
+// accepted.
+type Config struct {
+	PrimaryDriver   string        `json:"primary_driver"`
+	PrimaryDSN      string        `json:"primary_dsn"`
+	SecondaryDriver string        `json:"secondary_driver,omitempty"`
+	SecondaryDSN    string        `json:"secondary_dsn,omitempty"`
+	MaxQueryTime    time.Duration `json:"max_query_time,omitempty"`
+	MaxOpenConns    int           `json:"max_open_conns,omitempty"`
+	MaxIdleConns    int           `json:"max_idle_conns,omitempty"`
+	// RetryStrategy is one of "default", "exponential" or "immediate"
+	RetryStrategy string `json:"retry_strategy,omitempty"`
+}
+
+// MarshalJSON encodes MaxQueryTime as a duration string
+func (c Config) MarshalJSON() ([]byte, error) {
+	type config Config
+	v := struct {
+		config
+		MaxQueryTime string `json:"max_query_time,omitempty"`
+	}{config: config(c)}
+	if c.MaxQueryTime != 0 {
+		v.MaxQueryTime = c.MaxQueryTime.String()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes MaxQueryTime from a duration string or a number of
+// nanoseconds
+func (c *Config) UnmarshalJSON(b []byte) error {
+	type config Config
+	v := struct {
+		*config
+		MaxQueryTime json.RawMessage `json:"max_query_time,omitempty"`
+	}{config: (*config)(c)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if len(v.MaxQueryTime) == 0 || bytes.Equal(v.MaxQueryTime, []byte("null")) {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(v.MaxQueryTime, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(v.MaxQueryTime, &ns); err != nil {
+			return fmt.Errorf("retrydb: invalid max_query_time %s", v.MaxQueryTime)
+		}
+		c.MaxQueryTime = time.Duration(ns)
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("retrydb: invalid max_query_time: %w", err)
+	}
+	c.MaxQueryTime = d
+	return nil
+}
+
+// ExponentialRetryStrategy disables the Primary for 1s after the first
+// failure, doubling on each consecutive failure up to 120s
+func ExponentialRetryStrategy(retryCount uint32) time.Duration {
+	if retryCount >= 7 {
+		return 120 * time.Second
+	}
+	return time.Second << retryCount
+}
+
+// ImmediateRetry never disables the Primary; every query after a failure
+// probes the Primary again before falling back to the Secondary. It is mostly
+// useful for deterministic tests.
+func ImmediateRetry(retryCount uint32) time.Duration {
+	return 0
+}
+
+var retryStrategies = map[string]RetryStrategy{
+	"":            defaultRetryStrategy,
+	"default":     defaultRetryStrategy,
+	"exponential": ExponentialRetryStrategy,
+	"immediate":   ImmediateRetry,
+}
+
+// NewFromConfig opens the Primary and Secondary from c and applies all settings
+func NewFromConfig(c Config) (*RetryDB, error) {
+	strategy, ok := retryStrategies[c.RetryStrategy]
+	if !ok {
+		return nil, fmt.Errorf("retrydb: unknown retry strategy %q", c.RetryStrategy)
+	}
+	db, err := Open(c.PrimaryDriver, c.PrimaryDSN, c.SecondaryDriver, c.SecondaryDSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetRetryStrategy(strategy)
+	if c.MaxQueryTime > 0 {
+		db.SetMaxQueryTime(c.MaxQueryTime)
+	}
+	if c.MaxOpenConns != 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	return db, nil
+}
@@ -0,0 +1,114 @@
+package retrydb
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewFromConfig(t *testing.T) {
+	p := newFakeServer(t, "primary")
+	s := newFakeServer(t, "secondary")
+	c := Config{
+		PrimaryDriver:   fakeDriverName,
+		PrimaryDSN:      p.name,
+		SecondaryDriver: fakeDriverName,
+		SecondaryDSN:    s.name,
+		MaxQueryTime:    5 * time.Second,
+		MaxOpenConns:    10,
+		MaxIdleConns:    3,
+		RetryStrategy:   "exponential",
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Config
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != c {
+		t.Fatalf("got %+v expected %+v", decoded, c)
+	}
+	if !strings.Contains(string(b), `"max_query_time":"5s"`) {
+		t.Fatalf("got %s expected max_query_time as a duration string", b)
+	}
+
+	db, err := NewFromConfig(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	stats := db.Stats()
+	if stats.Primary.MaxOpenConnections != 10 || stats.Secondary.MaxOpenConnections != 10 {
+		t.Fatalf("got max open %d/%d", stats.Primary.MaxOpenConnections, stats.Secondary.MaxOpenConnections)
+	}
+	if db.maxQueryTime != 5*time.Second || db.retryStrategy(2) != 4*time.Second {
+		t.Fatalf("got maxQueryTime %s strategy(2) %s", db.maxQueryTime, db.retryStrategy(2))
+	}
+
+	decoded.RetryStrategy = "bogus"
+	if _, err := NewFromConfig(decoded); err == nil {
+		t.Fatal("expected error for unknown retry strategy")
+	}
+}
+
+func TestConfigMaxQueryTime(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		want time.Duration
+		err  bool
+	}{
+		{`{"max_query_time":"1.5s"}`, 1500 * time.Millisecond, false},
+		{`{"max_query_time":2000000}`, 2 * time.Millisecond, false},
+		{`{"max_query_time":null}`, 0, false},
+		{`{"primary_dsn":"p"}`, 0, false},
+		{`{"max_query_time":"soon"}`, 0, true},
+		{`{"max_query_time":true}`, 0, true},
+	} {
+		var c Config
+		err := json.Unmarshal([]byte(tc.json), &c)
+		if (err != nil) != tc.err {
+			t.Fatalf("%s: got error %v", tc.json, err)
+		}
+		if c.MaxQueryTime != tc.want {
+			t.Fatalf("%s: got %s expected %s", tc.json, c.MaxQueryTime, tc.want)
+		}
+	}
+
+	// the other fields still decode
+	var c Config
+	if err := json.Unmarshal([]byte(`{"primary_dsn":"p","max_query_time":"1s","max_open_conns":4}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.PrimaryDSN != "p" || c.MaxOpenConns != 4 || c.MaxQueryTime != time.Second {
+		t.Fatalf("got %+v", c)
+	}
+}
+
+func TestImmediateRetry(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetRetryStrategy(ImmediateRetry)
+	p.setErr(errTest)
+	for i := 1; i <= 3; i++ {
+		start := time.Now()
+		if v := queryValue(t, db, "select 1"); v != s.name {
+			t.Fatalf("got %q expected %q", v, s.name)
+		}
+		if p.queryCount() != i {
+			t.Fatalf("got %d primary queries expected %d", p.queryCount(), i)
+		}
+		// queries already in flight are not sent to the secondary either
+		if db.retrying(start) {
+			t.Fatal("expected no failover window")
+		}
+	}
+	p.setErr(nil)
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+}
@@ -0,0 +1,106 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryConn is a single dedicated connection obtained from RetryDB.Conn. All
+// queries run on the same connection, so session state (variables, temporary
+// tables) persists between calls. Failover is suspended for the life of the
+// RetryConn since session state can not migrate between endpoints.
+type RetryConn struct {
+	*sql.Conn
+	source Source
+}
+
+// Conn returns a dedicated connection from the currently active endpoint; the
+// Secondary while the Primary is disabled, otherwise the Primary. If a
+// connection to the Primary can not be established the failover window is
+// opened and a Secondary connection is returned.
+//
+// Note a RetryConn from the Secondary will also send Exec to the Secondary.
+// Use Source to check which endpoint the connection belongs to. Callers must
+// Close the RetryConn to return it to the pool.
+func (db *RetryDB) Conn(ctx context.Context) (*RetryConn, error) {
+	if db.secondaryActive() && db.retrying(time.Now()) {
+		return newRetryConn(ctx, db.Secondary, SourceSecondary)
+	}
+	c, err := newRetryConn(ctx, db.Primary, SourcePrimary)
+	if db.fatalError(SourcePrimary, err, nil) != nil && !isContextError(err) && db.secondaryActive() {
+		db.updateRetry(ctx, ReasonQueryError, fmt.Errorf("conn errored with %s. retrying against secondary", err))
+		return newRetryConn(ctx, db.Secondary, SourceSecondary)
+	}
+	return c, err
+}
+
+func newRetryConn(ctx context.Context, r Retryable, source Source) (*RetryConn, error) {
+	c, ok := r.(conner)
+	if !ok {
+		return nil, errors.New("retrydb: dedicated connections not supported by " + source.String())
+	}
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &RetryConn{Conn: conn, source: source}, nil
+}
+
+// Source returns the endpoint the connection belongs to
+func (c *RetryConn) Source() Source { return c.source }
+
+// Query on the dedicated connection
+func (c *RetryConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+// Exec on the dedicated connection
+func (c *RetryConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+// ConnRetryable adapts a *sql.Conn to the Retryable interface so a RetryDB can
+// be built over a dedicated connection with OpenWithRetryable. Context aware
+// methods of the underlying *sql.Conn are used where Retryable has none.
+type ConnRetryable struct {
+	*sql.Conn
+	driver driver.Driver
+}
+
+// NewConnRetryable wraps conn. d is returned from Driver, typically the
+// Driver of the *sql.DB conn was obtained from.
+func NewConnRetryable(conn *sql.Conn, d driver.Driver) *ConnRetryable {
+	return &ConnRetryable{Conn: conn, driver: d}
+}
+
+func (c *ConnRetryable) Begin() (*sql.Tx, error) {
+	return c.BeginTx(context.Background(), nil)
+}
+
+func (c *ConnRetryable) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *ConnRetryable) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c *ConnRetryable) Ping() error {
+	return c.PingContext(context.Background())
+}
+
+func (c *ConnRetryable) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c *ConnRetryable) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.QueryRowContext(context.Background(), query, args...)
+}
@@ -0,0 +1,82 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestConn(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	ctx := context.Background()
+
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Source() != SourcePrimary {
+		t.Fatalf("got %s expected primary", c.Source())
+	}
+	var first, second int64
+	if err := c.QueryRowContext(ctx, "select connection_id()").Scan(&first); err != nil {
+		t.Fatal(err)
+	}
+	// failover on the RetryDB does not move the dedicated connection
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	if err := c.QueryRowContext(ctx, "select connection_id()").Scan(&second); err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("got connection %d then %d", first, second)
+	}
+
+	s, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Source() != SourceSecondary {
+		t.Fatalf("got %s expected secondary", s.Source())
+	}
+}
+
+func TestConnRetryable(t *testing.T) {
+	p := newFakeServer(t, "primary")
+	s := newFakeServer(t, "secondary")
+	pdb, err := sql.Open(fakeDriverName, p.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdb.Close()
+	sdb, err := sql.Open(fakeDriverName, s.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+	conn, err := pdb.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var _ Retryable = (*ConnRetryable)(nil)
+	db := OpenWithRetryable(NewConnRetryable(conn, pdb.Driver()), sdb)
+	db.SetRetryStrategy(func(uint32) time.Duration { return time.Minute })
+	db.SetLogger(log.New(io.Discard, "", 0))
+	defer db.Close()
+
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if db.Driver() != pdb.Driver() {
+		t.Fatal("unexpected driver")
+	}
+}
@@ -0,0 +1,73 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Consistency is a read consistency level for a single query; see
+// WithConsistency
+type Consistency struct {
+	level  consistencyLevel
+	maxLag time.Duration
+}
+
+type consistencyLevel int
+
+const (
+	strongConsistency consistencyLevel = iota + 1
+	boundedConsistency
+	eventualConsistency
+)
+
+var (
+	// Strong reads always query the Primary and return its errors without
+	// failover, like ForcePrimary
+	Strong = Consistency{level: strongConsistency}
+	// Eventual reads query the Secondary, falling back to the Primary like
+	// SecondaryPreferred
+	Eventual = Consistency{level: eventualConsistency}
+)
+
+// Bounded reads query the Secondary (falling back to the Primary on error)
+// only when its replication lag, as reported by the SetMaxReplicaLag lag
+// function, is below maxLag. Otherwise, or without a lag function, they are
+// Strong reads.
+func Bounded(maxLag time.Duration) Consistency {
+	return Consistency{level: boundedConsistency, maxLag: maxLag}
+}
+
+// WithConsistency returns a context that makes QueryContext route the query
+// by c instead of the read preference and failover window
+func WithConsistency(ctx context.Context, c Consistency) context.Context {
+	return context.WithValue(ctx, consistencyKey, c)
+}
+
+func consistencyFrom(ctx context.Context) (Consistency, bool) {
+	c, ok := ctx.Value(consistencyKey).(Consistency)
+	return c, ok && c.level != 0
+}
+
+// consistencyQuery routes query by the consistency level c
+func (db *RetryDB) consistencyQuery(ctx context.Context, c Consistency, query string, args ...interface{}) (*sql.Rows, QueryInfo, error) {
+	ctx, secondary := db.withSecondary(ctx)
+	if c.level == eventualConsistency || (c.level == boundedConsistency && db.withinLag(ctx, secondary, c.maxLag)) {
+		return db.secondaryPreferredQuery(ctx, query, args...)
+	}
+	rows, err := db.primaryQueryRetry(ctx, query, args...)
+	return rows, QueryInfo{Source: SourcePrimary}, err
+}
+
+// withinLag reports whether the replication lag of secondary is known to be
+// below max
+func (db *RetryDB) withinLag(ctx context.Context, secondary Retryable, max time.Duration) bool {
+	db.RLock()
+	lagFunc := db.replicaLag
+	db.RUnlock()
+	if lagFunc == nil {
+		return false
+	}
+	lag, err := db.replicaLagOf(ctx, lagFunc, secondary)
+	return err == nil && lag < max
+}
@@ -0,0 +1,39 @@
+package retrydb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithConsistency(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	lag := 5 * time.Second
+	var lagErr error
+	db.SetMaxReplicaLag(time.Hour, func(ctx context.Context, r Retryable) (time.Duration, error) {
+		return lag, lagErr
+	})
+	check := func(name string, c Consistency, want Source) {
+		t.Helper()
+		rows, info, err := db.QueryWithInfo(WithConsistency(context.Background(), c), "select 1")
+		scanValue(t, rows, err)
+		if info.Source != want {
+			t.Fatalf("%s: got %s expected %s", name, info.Source, want)
+		}
+	}
+
+	check("strong", Strong, SourcePrimary)
+	check("eventual", Eventual, SourceSecondary)
+	check("bounded within lag", Bounded(10*time.Second), SourceSecondary)
+	check("bounded over lag", Bounded(time.Second), SourcePrimary)
+	lagErr = errTest
+	check("bounded unknown lag", Bounded(10*time.Second), SourcePrimary)
+
+	// strong reads ignore the failover window
+	lagErr = nil
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	p.setErr(nil)
+	check("strong in failover", Strong, SourcePrimary)
+	check("default in failover", Consistency{}, SourceSecondary)
+}
@@ -0,0 +1,156 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+)
+
+type queryContexter interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryContext runs query against r, passing ctx through when r supports it
+func queryContext(ctx context.Context, r Retryable, query string, args ...interface{}) (*sql.Rows, error) {
+	if q, ok := r.(queryContexter); ok {
+		return q.QueryContext(ctx, query, args...)
+	}
+	return r.Query(query, args...)
+}
+
+type pingContexter interface {
+	PingContext(ctx context.Context) error
+}
+
+// pingContext pings r, passing ctx through when r supports it
+func pingContext(ctx context.Context, r Retryable) error {
+	if p, ok := r.(pingContexter); ok {
+		return p.PingContext(ctx)
+	}
+	return r.Ping()
+}
+
+type conner interface {
+	Conn(ctx context.Context) (*sql.Conn, error)
+}
+
+type contextKey int
+
+const (
+	forcePrimaryKey contextKey = iota
+	consistencyTokenKey
+	queryTagKey
+	queryTimingKey
+	attemptsKey
+	consistencyKey
+	maxStalenessKey
+	stickySessionKey
+	secondaryKey
+	rowsClosersKey
+)
+
+// maxQueryTagLen is the length SQL is truncated to when used as a query tag
+const maxQueryTagLen = 128
+
+// WithQueryTag returns a context that labels queries with tag (for example
+// "get_user_by_id"). The tag is passed to the SetOnQuery callback in place of
+// the SQL to keep metric cardinality low.
+func WithQueryTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, queryTagKey, tag)
+}
+
+// queryTag returns the tag set with WithQueryTag, or query with whitespace
+// collapsed (or normalized; see SetNormalizeQueries), truncated to
+// maxQueryTagLen
+func (db *RetryDB) queryTag(ctx context.Context, query string) string {
+	if tag, ok := ctx.Value(queryTagKey).(string); ok {
+		return tag
+	}
+	db.RLock()
+	normalize := db.normalizeQueries
+	db.RUnlock()
+	if normalize {
+		query = normalizeQuery(query)
+	} else {
+		query = strings.Join(strings.Fields(query), " ")
+	}
+	if len(query) > maxQueryTagLen {
+		query = query[:maxQueryTagLen]
+	}
+	return query
+}
+
+// ForcePrimary returns a context that makes QueryContext always query the
+// Primary, ignoring any failover window or PinSecondary, and return Primary
+// errors without retrying against the Secondary. See QueryPrimary.
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey, true)
+}
+
+func isForcePrimary(ctx context.Context) bool {
+	force, _ := ctx.Value(forcePrimaryKey).(bool)
+	return force
+}
+
+// QueryContext is Query with a context. In addition to ctx, the query is
+// cancelled when Shutdown is called, and fails with context.Canceled once
+// Shutdown has been called.
+func (db *RetryDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	rows, _, err := db.linkedQuery(ctx, query, args...)
+	return rows, err
+}
+
+// QueryWithInfo is QueryContext that also returns how the result was
+// obtained: the number of attempts, the Source that served it, whether it
+// failed over and the total duration.
+func (db *RetryDB) QueryWithInfo(ctx context.Context, query string, args ...interface{}) (*sql.Rows, QueryInfo, error) {
+	var attempts atomic.Int32
+	ctx = context.WithValue(ctx, attemptsKey, &attempts)
+	rows, info, err := db.linkedQuery(ctx, query, args...)
+	info.Attempts = int(attempts.Load())
+	return rows, info, err
+}
+
+// countAttempt counts a query sent to an endpoint for QueryWithInfo
+func countAttempt(ctx context.Context) {
+	if attempts, ok := ctx.Value(attemptsKey).(*atomic.Int32); ok {
+		attempts.Add(1)
+	}
+}
+
+// linkedQuery runs query with ctx also cancelled by Shutdown while it is
+// pending. When ctx itself is done the error is context.Cause(ctx), so a cause
+// from context.WithCancelCause reaches the caller.
+func (db *RetryDB) linkedQuery(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, info QueryInfo, err error) {
+	parent := ctx
+	if db.ctx != nil {
+		if err := db.ctx.Err(); err != nil {
+			return nil, QueryInfo{}, err
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		// only link to the base context while the query is pending; once rows
+		// are returned they are the callers responsibility to Close
+		stop := context.AfterFunc(db.ctx, cancel)
+		defer stop()
+		var closers *rowsClosers
+		ctx, closers = withRowsClosers(ctx)
+		// the rows share ctx, so only release it once they are closed
+		defer func() { closers.onClose(rows, cancel) }()
+	}
+	rows, info, err = db.query(ctx, query, args...)
+	if isContextError(err) && parent.Err() != nil {
+		err = context.Cause(parent)
+	}
+	return rows, info, err
+}
+
+// Shutdown cancels all pending QueryContext calls and causes future
+// QueryContext calls to fail with context.Canceled. Query (without a context)
+// is unaffected. Shutdown does not close the underlying connection pools.
+func (db *RetryDB) Shutdown() {
+	if db.cancel != nil {
+		db.cancel()
+	}
+}
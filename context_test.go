@@ -0,0 +1,301 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownCancelsQueryContext(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	started := make(chan struct{})
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	})
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := db.QueryContext(context.Background(), "select 1")
+		errc <- err
+	}()
+	<-started
+	db.Shutdown()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v expected context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("QueryContext not cancelled by Shutdown")
+	}
+
+	// Query without a context is unaffected, and the cancellation did not
+	// disable the primary
+	p.setHandler(nil)
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+}
+
+func TestQueryContextReleasedOnClose(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	var queryCtx context.Context
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		queryCtx = ctx
+		return []string{"source"}, [][]driver.Value{{p.name}}, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the context linked to Shutdown lives until the rows are closed
+	linked := queryCtx.(*closeCtx).Context
+	if err := linked.Err(); err != nil {
+		t.Fatalf("got %v before rows were closed", err)
+	}
+	if v := scanValue(t, rows, err); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if err := linked.Err(); err != context.Canceled {
+		t.Fatalf("got %v expected context.Canceled once rows were closed", err)
+	}
+
+	db.Shutdown()
+	if _, err := db.QueryContext(ctx, "select 1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v expected context.Canceled after Shutdown", err)
+	}
+	if n := p.queryCount(); n != 1 {
+		t.Fatalf("got %d primary queries expected 1", n)
+	}
+}
+
+func TestPinSecondary(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.PinSecondary(20 * time.Millisecond)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+
+	// ForcePrimary overrides the pin
+	rows, err := db.QueryContext(ForcePrimary(context.Background()), "select 1")
+	if v := scanValue(t, rows, err); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q after pin expired", v, p.name)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+}
+
+func TestForcePrimary(t *testing.T) {
+	db, p, s := newTestDB(t)
+	p.setErr(errTest)
+	ctx := ForcePrimary(context.Background())
+	if _, err := db.QueryContext(ctx, "select 1"); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if s.queryCount() != 0 || db.Stats().RetryCount != 0 {
+		t.Fatal("expected no failover")
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetQueryTimeout(50 * time.Millisecond)
+	p.setDelay(30 * time.Millisecond)
+	p.setErr(errTest)
+	s.setDelay(50 * time.Millisecond)
+
+	start := time.Now()
+	_, err := db.Query("select 1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v expected %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 75*time.Millisecond {
+		t.Fatalf("query took %s", d)
+	}
+
+	// within the budget the secondary result is returned
+	s.setDelay(0)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+
+	// the deadline is released once the rows are closed
+	var queryCtx context.Context
+	s.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		queryCtx = ctx
+		return []string{"source"}, [][]driver.Value{{s.name}}, nil
+	})
+	rows, err := db.Query("select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := queryCtx.(*closeCtx).Context
+	if err := timeout.Err(); err != nil {
+		t.Fatalf("got %v before rows were closed", err)
+	}
+	rows.Close()
+	if err := timeout.Err(); err != context.Canceled {
+		t.Fatalf("got %v expected context.Canceled once rows were closed", err)
+	}
+}
+
+func TestQueryTimeoutHungPrimary(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetQueryTimeout(20 * time.Millisecond)
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	})
+	s.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		return []string{"source"}, [][]driver.Value{{s.name}}, nil
+	})
+
+	// the timeout counts as a Primary failure even though it is a context error
+	if _, err := db.Query("select 1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v expected %v", err, context.DeadlineExceeded)
+	}
+	if st := db.Stats(); st.RetryCount != 1 || st.TotalFailovers != 1 {
+		t.Fatalf("got retry count %d and %d failovers expected 1", st.RetryCount, st.TotalFailovers)
+	}
+	for i := 0; i < 2; i++ {
+		if v := queryValue(t, db, "select 1"); v != s.name {
+			t.Fatalf("got %q expected %q", v, s.name)
+		}
+	}
+	if n := p.queryCount(); n != 1 {
+		t.Fatalf("got %d primary queries expected 1", n)
+	}
+
+	// a deadline from the caller still never opens the window
+	db.ResetCircuit(SourcePrimary)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := db.QueryContext(ctx, "select 1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v expected %v", err, context.DeadlineExceeded)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+}
+
+func TestWithQueryTag(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	var tag string
+	db.SetOnQuery(func(query string, s Source, d time.Duration, err error) {
+		tag = query
+	})
+
+	ctx := WithQueryTag(context.Background(), "get_user_by_id")
+	rows, err := db.QueryContext(ctx, "select *\n\tfrom users where id = ?", 1)
+	scanValue(t, rows, err)
+	if tag != "get_user_by_id" {
+		t.Fatalf("got %q", tag)
+	}
+
+	rows, err = db.QueryContext(context.Background(), "select *\n\tfrom users where id = ?", 1)
+	scanValue(t, rows, err)
+	if tag != "select * from users where id = ?" {
+		t.Fatalf("got %q", tag)
+	}
+	queryValue(t, db, "select '"+strings.Repeat("x", 200)+"'")
+	if len(tag) != maxQueryTagLen {
+		t.Fatalf("got tag length %d", len(tag))
+	}
+}
+
+func TestContextErrorsNotFatal(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	p.setDelay(20 * time.Millisecond)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 10; i++ {
+		if _, err := db.QueryContext(cancelled, "select 1"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v expected %v", err, context.Canceled)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		_, err := db.QueryContext(ctx, "select 1")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v expected %v", err, context.DeadlineExceeded)
+		}
+	}
+	if _, err := db.BatchQuery(cancelled, []Query{{SQL: "select 1"}}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v expected %v", err, context.Canceled)
+	}
+	if st := db.Stats(); st.RetryCount != 0 || st.TotalFailovers != 0 {
+		t.Fatalf("got retry count %d, %d failovers", st.RetryCount, st.TotalFailovers)
+	}
+}
+
+func TestQueryWithInfo(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	ctx := context.Background()
+	p.setDelay(2 * time.Millisecond)
+	check := func(name string, want QueryInfo) {
+		t.Helper()
+		rows, info, err := db.QueryWithInfo(ctx, "select 1")
+		scanValue(t, rows, err)
+		if info.Duration <= 0 {
+			t.Fatalf("%s: got duration %s", name, info.Duration)
+		}
+		info.Duration = 0
+		if info != want {
+			t.Fatalf("%s: got %+v expected %+v", name, info, want)
+		}
+	}
+	check("primary", QueryInfo{Attempts: 1, Source: SourcePrimary})
+	p.setErr(errTest)
+	check("failover", QueryInfo{Attempts: 2, Source: SourceSecondary, Failover: true, Stale: true})
+	check("secondary window", QueryInfo{Attempts: 1, Source: SourceSecondary, Stale: true})
+
+	db.updateRetry(ctx, ReasonNone, nil)
+	db.SetConnRetries(2, 0)
+	p.setErr(syscall.ECONNRESET)
+	check("conn retries", QueryInfo{Attempts: 4, Source: SourceSecondary, Failover: true, Stale: true})
+
+	db.updateRetry(ctx, ReasonNone, nil)
+	p.setErr(nil)
+	ctx = ForcePrimary(ctx)
+	check("force primary", QueryInfo{Attempts: 1, Source: SourcePrimary})
+}
+
+func TestQueryContextCause(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	p.setDelay(time.Second)
+	errDeploy := errors.New("deploy in progress")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(5*time.Millisecond, func() { cancel(errDeploy) })
+	if _, err := db.QueryContext(ctx, "select 1"); err != errDeploy {
+		t.Fatalf("got %v expected %v", err, errDeploy)
+	}
+
+	// without a cause the context error is returned
+	ctx, cancelCtx := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancelCtx()
+	if _, err := db.QueryContext(ctx, "select 1"); err != context.DeadlineExceeded {
+		t.Fatalf("got %v expected %v", err, context.DeadlineExceeded)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+}
@@ -0,0 +1,300 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDriverName is registered with database/sql; the DSN names a fakeServer
+const fakeDriverName = "retrydbfake"
+
+var errTest = errors.New("test error")
+
+var fakeServers sync.Map // dsn -> *fakeServer
+
+func init() {
+	sql.Register(fakeDriverName, fakeDriver{})
+}
+
+type fakeHandler func(ctx context.Context, query string, args []driver.NamedValue) (columns []string, rows [][]driver.Value, err error)
+
+// fakeServer is an in-memory database. By default every query returns a
+// single row with a single column containing the server name so tests can
+// tell which endpoint served a query. The query "select connection_id()"
+// returns a unique id for the connection.
+type fakeServer struct {
+	name string
+
+	sync.Mutex
+	err       error         // returned from every query and exec
+	delay     time.Duration // applied before every query and exec
+	handler   fakeHandler   // overrides the default query behavior
+	queries   []string
+	execs     []string
+	commits   int
+	rollbacks int
+	prepares  int
+}
+
+func newFakeServer(t testing.TB, name string) *fakeServer {
+	s := &fakeServer{name: t.Name() + "/" + name}
+	for i := 2; ; i++ {
+		if _, loaded := fakeServers.LoadOrStore(s.name, s); !loaded {
+			break
+		}
+		s.name = fmt.Sprintf("%s/%s%d", t.Name(), name, i)
+	}
+	t.Cleanup(func() { fakeServers.Delete(s.name) })
+	return s
+}
+
+// newTestDB returns a RetryDB backed by fake primary and secondary servers.
+// The retry strategy disables the primary for a minute on every failure and
+// log output is discarded.
+func newTestDB(t testing.TB) (*RetryDB, *fakeServer, *fakeServer) {
+	p := newFakeServer(t, "primary")
+	s := newFakeServer(t, "secondary")
+	db, err := Open(fakeDriverName, p.name, fakeDriverName, s.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetRetryStrategy(func(uint32) time.Duration { return time.Minute })
+	db.SetLogger(log.New(io.Discard, "", 0))
+	t.Cleanup(func() { db.Close() })
+	return db, p, s
+}
+
+func (s *fakeServer) setErr(err error) {
+	s.Lock()
+	s.err = err
+	s.Unlock()
+}
+
+func (s *fakeServer) setDelay(d time.Duration) {
+	s.Lock()
+	s.delay = d
+	s.Unlock()
+}
+
+func (s *fakeServer) setHandler(h fakeHandler) {
+	s.Lock()
+	s.handler = h
+	s.Unlock()
+}
+
+func (s *fakeServer) queryCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.queries)
+}
+
+// queryAt returns the i'th query run against s
+func (s *fakeServer) queryAt(i int) string {
+	s.Lock()
+	defer s.Unlock()
+	return s.queries[i]
+}
+
+func (s *fakeServer) execCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.execs)
+}
+
+func (s *fakeServer) wait(ctx context.Context) error {
+	s.Lock()
+	delay := s.delay
+	s.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *fakeServer) query(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s.Lock()
+	s.queries = append(s.queries, query)
+	err, handler := s.err, s.handler
+	s.Unlock()
+	if werr := s.wait(ctx); werr != nil {
+		return nil, werr
+	}
+	if handler != nil {
+		columns, rows, err := handler(ctx, query, args)
+		if err != nil {
+			return nil, err
+		}
+		return &fakeRows{columns: columns, rows: rows}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: []string{"source"}, rows: [][]driver.Value{{s.name}}}, nil
+}
+
+func (s *fakeServer) exec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	s.Lock()
+	s.execs = append(s.execs, query)
+	err := s.err
+	s.Unlock()
+	if werr := s.wait(ctx); werr != nil {
+		return nil, werr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	s, ok := fakeServers.Load(name)
+	if !ok {
+		return nil, errors.New("fakedriver: unknown server " + name)
+	}
+	return &fakeConn{server: s.(*fakeServer), id: fakeConnID.Add(1)}, nil
+}
+
+var fakeConnID atomic.Int64
+
+type fakeConn struct {
+	server *fakeServer
+	id     int64
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.server.Lock()
+	c.server.prepares++
+	c.server.Unlock()
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.server.Lock()
+	defer c.server.Unlock()
+	if c.server.err != nil {
+		return nil, c.server.err
+	}
+	return fakeTx{c.server}, nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	c.server.Lock()
+	defer c.server.Unlock()
+	return c.server.err
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if query == "select connection_id()" {
+		return &fakeRows{columns: []string{"id"}, rows: [][]driver.Value{{c.id}}}, nil
+	}
+	return c.server.query(ctx, query, args)
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.server.exec(ctx, query, args)
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("fakedriver: use ExecContext")
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("fakedriver: use QueryContext")
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+type fakeTx struct {
+	server *fakeServer
+}
+
+func (tx fakeTx) Commit() error {
+	tx.server.Lock()
+	tx.server.commits++
+	tx.server.Unlock()
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.server.Lock()
+	tx.server.rollbacks++
+	tx.server.Unlock()
+	return nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// queryValue runs db.Query and returns the first column of the first row
+func queryValue(t testing.TB, db *RetryDB, query string, args ...interface{}) string {
+	t.Helper()
+	rows, err := db.Query(query, args...)
+	return scanValue(t, rows, err)
+}
+
+// scanValue returns the first column of the first row
+func scanValue(t testing.TB, rows *sql.Rows, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var v string
+	if !rows.Next() {
+		t.Fatalf("no rows %v", rows.Err())
+	}
+	if err := rows.Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
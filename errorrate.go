@@ -0,0 +1,69 @@
+package retrydb
+
+import (
+	"time"
+)
+
+// outcomeRingSize is the number of recent Primary query outcomes considered
+// by SetFailoverErrorRate
+const outcomeRingSize = 128
+
+// outcomeRing is a ring buffer of recent Primary query outcomes
+type outcomeRing struct {
+	at     [outcomeRingSize]time.Time
+	failed [outcomeRingSize]bool
+	next   int
+}
+
+func (r *outcomeRing) add(t time.Time, failed bool) {
+	r.at[r.next] = t
+	r.failed[r.next] = failed
+	r.next = (r.next + 1) % outcomeRingSize
+}
+
+// errorRate returns the fraction of failed outcomes since t
+func (r *outcomeRing) errorRate(since time.Time) float64 {
+	var total, failed int
+	for i, at := range r.at {
+		if at.IsZero() || at.Before(since) {
+			continue
+		}
+		total++
+		if r.failed[i] {
+			failed++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(failed) / float64(total)
+}
+
+// SetFailoverErrorRate only opens the failover window when more than
+// threshold (0.0-1.0) of the recent Primary queries within window have
+// failed. Individual failing queries are still retried against the Secondary.
+// Outcomes are tracked for the most recent 128 Primary queries. A threshold of
+// 0 restores the default of opening the window on any failure.
+func (db *RetryDB) SetFailoverErrorRate(threshold float64, window time.Duration) {
+	db.Lock()
+	db.errorRateThreshold = threshold
+	db.errorRateWindow = window
+	db.outcomes = outcomeRing{}
+	db.Unlock()
+}
+
+// recordPrimaryOutcome tracks the result of a Primary query and returns true
+// if the failover window should be opened
+func (db *RetryDB) recordPrimaryOutcome(failed bool) bool {
+	db.RLock()
+	enabled := db.errorRateThreshold > 0
+	db.RUnlock()
+	if !enabled {
+		return failed
+	}
+	now := time.Now()
+	db.Lock()
+	defer db.Unlock()
+	db.outcomes.add(now, failed)
+	return failed && db.outcomes.errorRate(now.Add(-db.errorRateWindow)) > db.errorRateThreshold
+}
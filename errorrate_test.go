@@ -0,0 +1,35 @@
+package retrydb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailoverErrorRate(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetFailoverErrorRate(0.5, time.Minute)
+	for i := 0; i < 10; i++ {
+		queryValue(t, db, "select 1")
+	}
+
+	// a sporadic error is retried on the secondary without failing over
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+
+	// a sustained burst fails over
+	for i := 0; db.Stats().RetryCount == 0; i++ {
+		if i > 10 {
+			t.Fatal("expected failover")
+		}
+		queryValue(t, db, "select 1")
+	}
+	// 10 successes, 11 failures
+	if n := p.queryCount(); n != 21 {
+		t.Fatalf("got %d primary queries expected 21", n)
+	}
+}
@@ -0,0 +1,223 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// IsFatalError reports whether a query error should trigger failover. Any error
+// other than sql.ErrNoRows is fatal, which includes dead connection errors
+// driver.ErrBadConn and sql.ErrConnDone. Wrapped errors are detected with
+// errors.Is.
+func IsFatalError(err error) bool {
+	return err != nil && !errors.Is(err, sql.ErrNoRows)
+}
+
+// isContextError reports whether err is from a cancelled context or expired
+// deadline. These never open the failover window since they say nothing
+// about the health of the endpoint.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// errQueryTimeout is the cause of the SetQueryTimeout deadline. Unlike a
+// context error from the caller it counts as a failure of the endpoint that
+// hit it.
+var errQueryTimeout = errors.New("retrydb: query timeout")
+
+// callerContextError reports whether err is a context error that was not
+// caused by the SetQueryTimeout deadline of ctx
+func callerContextError(ctx context.Context, err error) bool {
+	return isContextError(err) && context.Cause(ctx) != errQueryTimeout
+}
+
+// isConnError reports whether err is a transient connection-level error
+// worth retrying against the same endpoint
+func isConnError(err error) bool {
+	switch {
+	case err == nil, isContextError(err):
+		return false
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+type sqlStater interface {
+	SQLState() string
+}
+
+// IsDeadlock reports whether err is a deadlock or serialization failure; MySQL
+// error 1213 or SQLSTATE 40001 / 40P01. It is the default classifier for
+// SetDeadlockRetry.
+func IsDeadlock(err error) bool {
+	if err == nil {
+		return false
+	}
+	var s sqlStater
+	if errors.As(err, &s) {
+		switch s.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Error 1213") || strings.Contains(msg, "Deadlock found") || strings.Contains(msg, "deadlock detected")
+}
+
+// IsReadOnlyError reports whether err is from a write to a read-only server,
+// such as a former primary that came back as a replica; MySQL error 1290 or
+// the PostgreSQL SQLSTATE 25006 read_only_sql_transaction.
+func IsReadOnlyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var s sqlStater
+	if errors.As(err, &s) && s.SQLState() == "25006" {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Error 1290") || strings.Contains(msg, "--read-only") || strings.Contains(msg, "read-only transaction")
+}
+
+// SetPrimaryErrorClassifier sets the function that decides whether a Primary
+// query error is fatal and triggers failover. The default is IsFatalError.
+// Use it with SetSecondaryErrorClassifier when the endpoints use drivers with
+// different error semantics.
+func (db *RetryDB) SetPrimaryErrorClassifier(isFatal func(error) bool) {
+	db.Lock()
+	db.primaryIsFatal = isFatal
+	db.Unlock()
+}
+
+// SetSecondaryErrorClassifier sets the function that decides whether a
+// Secondary query error counts as a failure, such as for SetFailFast. The
+// default is IsFatalError.
+func (db *RetryDB) SetSecondaryErrorClassifier(isFatal func(error) bool) {
+	db.Lock()
+	db.secondaryIsFatal = isFatal
+	db.Unlock()
+}
+
+// SetFailoverSQLStates sets the Primary error classifier to fail over only on
+// errors whose SQLSTATE class (the first two characters) is one of classes,
+// for example "08" connection exception or "57" operator intervention. The
+// SQLSTATE is read from errors implementing SQLState() string (as pq and pgx
+// errors do); other errors are classified with IsFatalError.
+func (db *RetryDB) SetFailoverSQLStates(classes []string) {
+	set := make(map[string]bool, len(classes))
+	for _, c := range classes {
+		if len(c) > 2 {
+			c = c[:2]
+		}
+		set[strings.ToUpper(c)] = true
+	}
+	db.SetPrimaryErrorClassifier(func(err error) bool {
+		var s sqlStater
+		if !errors.As(err, &s) || len(s.SQLState()) < 2 {
+			return IsFatalError(err)
+		}
+		return set[strings.ToUpper(s.SQLState()[:2])]
+	})
+}
+
+// SetFailoverDecider sets a hook that makes the final decision on whether a
+// Primary query error, or a slow query (see SetMaxQueryTime), fails over,
+// overriding the error classifier. It is passed the error and how long the
+// query took. Returning false returns the error (or the slow result) to the
+// caller without opening the failover window. Context errors never reach it.
+func (db *RetryDB) SetFailoverDecider(f func(ctx context.Context, err error, query string, d time.Duration) bool) {
+	db.Lock()
+	db.failoverDecider = f
+	db.Unlock()
+}
+
+// decideFailover applies the SetFailoverDecider hook; ok is false without one
+func (db *RetryDB) decideFailover(ctx context.Context, err error, query string, d time.Duration) (failover, ok bool) {
+	db.RLock()
+	decide := db.failoverDecider
+	db.RUnlock()
+	if decide == nil {
+		return false, false
+	}
+	// a decider that panics leaves the decision to the error classifier
+	db.callback(ctx, "failover decider", func() {
+		failover = decide(ctx, err, query, d)
+		ok = true
+	})
+	return failover, ok
+}
+
+// primaryError applies the error classifier and the failover decider to the
+// result of a Primary query that took d. ferr is the error to fail over on,
+// or nil on success. stands is true when the result is returned as is
+// without counting as either, such as for a context error from the caller.
+func (db *RetryDB) primaryError(ctx context.Context, err error, rows *sql.Rows, query string, d time.Duration) (ferr error, stands bool) {
+	ferr = db.fatalError(SourcePrimary, err, rows)
+	if callerContextError(ctx, ferr) {
+		return nil, true
+	}
+	if qerr := queryError(err, rows); qerr != nil && !isContextError(qerr) {
+		if fail, ok := db.decideFailover(ctx, qerr, query, d); ok {
+			if !fail {
+				return nil, true
+			}
+			ferr = qerr
+		}
+	}
+	return ferr, false
+}
+
+// queryError returns err, or the error from iterating rows when err is nil
+func queryError(err error, rows *sql.Rows) error {
+	if err == nil && rows != nil {
+		return rows.Err()
+	}
+	return err
+}
+
+// fatalError is getFatalError using the error classifier for source
+func (db *RetryDB) fatalError(source Source, err error, rows *sql.Rows) error {
+	db.RLock()
+	isFatal := db.primaryIsFatal
+	if source == SourceSecondary {
+		isFatal = db.secondaryIsFatal
+	}
+	db.RUnlock()
+	if isFatal == nil {
+		return getFatalError(err, rows)
+	}
+	if err != nil {
+		if db.classify(isFatal, err) {
+			return err
+		}
+		return nil
+	}
+	if rows != nil {
+		if err := rows.Err(); err != nil && db.classify(isFatal, err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// classify runs the error classifier isFatal, falling back to IsFatalError
+// if it panics
+func (db *RetryDB) classify(isFatal func(error) bool, err error) bool {
+	fatal := IsFatalError(err)
+	db.callback(context.Background(), "error classifier", func() { fatal = isFatal(err) })
+	return fatal
+}
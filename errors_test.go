@@ -0,0 +1,198 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsFatalError(t *testing.T) {
+	tests := []struct {
+		err   error
+		fatal bool
+	}{
+		{nil, false},
+		{sql.ErrNoRows, false},
+		{fmt.Errorf("lookup: %w", sql.ErrNoRows), false},
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("query: %w", driver.ErrBadConn), true},
+		{sql.ErrConnDone, true},
+		{fmt.Errorf("query: %w", sql.ErrConnDone), true},
+		{errTest, true},
+	}
+	for _, tc := range tests {
+		if got := IsFatalError(tc.err); got != tc.fatal {
+			t.Errorf("IsFatalError(%v) got %v expected %v", tc.err, got, tc.fatal)
+		}
+	}
+}
+
+func TestWrappedConnDoneFailover(t *testing.T) {
+	db, p, s := newTestDB(t)
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return nil, nil, fmt.Errorf("primary: %w", sql.ErrConnDone)
+	})
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+
+	// a wrapped ErrNoRows does not fail over
+	db, p, _ = newTestDB(t)
+	p.setErr(fmt.Errorf("primary: %w", sql.ErrNoRows))
+	if _, err := db.Query("select 1"); err == nil || db.Stats().RetryCount != 0 {
+		t.Fatalf("got %v with retry count %d", err, db.Stats().RetryCount)
+	}
+}
+
+func TestErrorClassifiers(t *testing.T) {
+	db, p, s := newTestDB(t)
+	errMySQL := errors.New("mysql: server gone")
+	errProxy := errors.New("proxy: backend unavailable")
+	db.SetPrimaryErrorClassifier(func(err error) bool { return err == errMySQL })
+	db.SetSecondaryErrorClassifier(func(err error) bool { return err == errProxy })
+	db.SetFailFast(time.Minute)
+
+	// not fatal for the primary; returned without failover
+	p.setErr(errTest)
+	if _, err := db.Query("select 1"); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+
+	p.setErr(errMySQL)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+
+	// errTest is not fatal for the secondary so its circuit stays closed
+	s.setErr(errTest)
+	for i := 0; i < 2; i++ {
+		if _, err := db.Query("select 1"); err != errTest {
+			t.Fatalf("got %v expected %v", err, errTest)
+		}
+	}
+	s.setErr(errProxy)
+	db.Query("select 1")
+	if _, err := db.Query("select 1"); err != ErrNoHealthyEndpoint {
+		t.Fatalf("got %v expected %v", err, ErrNoHealthyEndpoint)
+	}
+}
+
+func TestReadOnlyPrimary(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		readOnly bool
+	}{
+		{nil, false},
+		{errTest, false},
+		{errors.New("Error 1290 (HY000): The MySQL server is running with the --read-only option so it cannot execute this statement"), true},
+		{sqlStateError("25006"), true},
+		{errors.New("pq: cannot execute INSERT in a read-only transaction"), true},
+	} {
+		if got := IsReadOnlyError(tc.err); got != tc.readOnly {
+			t.Errorf("IsReadOnlyError(%v) got %v expected %v", tc.err, got, tc.readOnly)
+		}
+	}
+
+	db, p, s := newTestDB(t)
+	events := db.FailoverEvents()
+	readOnly := errors.New("Error 1290 (HY000): The MySQL server is running with the --read-only option")
+	p.setErr(readOnly)
+	if _, err := db.Exec("insert into t values (1)"); err != readOnly {
+		t.Fatalf("got %v expected %v", err, readOnly)
+	}
+	if e := <-events; e.Reason != ReasonReadOnly {
+		t.Fatalf("got reason %s expected %s", e.Reason, ReasonReadOnly)
+	}
+	// reads move to the secondary
+	p.setErr(nil)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+}
+
+func TestFailoverSQLStates(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetFailoverSQLStates([]string{"08", "57"})
+
+	// a constraint violation is returned without failover
+	p.setErr(sqlStateError("23505"))
+	if _, err := db.Query("select 1"); err != sqlStateError("23505") {
+		t.Fatalf("got %v expected %v", err, sqlStateError("23505"))
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+
+	for _, err := range []error{sqlStateError("08006"), fmt.Errorf("wrapped: %w", sqlStateError("57P01")), errTest} {
+		db.updateRetry(context.Background(), ReasonNone, nil)
+		p.setErr(err)
+		if v := queryValue(t, db, "select 1"); v != s.name {
+			t.Fatalf("%v: got %q expected %q", err, v, s.name)
+		}
+	}
+}
+
+func TestBothEndpointsFail(t *testing.T) {
+	db, p, s := newTestDB(t)
+	p.setErr(errTest)
+	s.setErr(sqlStateError("08006"))
+
+	_, err := db.Query("select 1")
+	if !errors.Is(err, errTest) {
+		t.Fatalf("got %v expected primary error %v", err, errTest)
+	}
+	var stateErr sqlStateError
+	if !errors.As(err, &stateErr) || stateErr != "08006" {
+		t.Fatalf("got %v expected secondary error", err)
+	}
+	if errs := err.(interface{ Unwrap() []error }).Unwrap(); len(errs) != 2 {
+		t.Fatalf("got %d errors expected 2", len(errs))
+	}
+}
+
+func TestFailoverDecider(t *testing.T) {
+	db, p, s := newTestDB(t)
+	maintenance := true
+	var decided []error
+	db.SetFailoverDecider(func(ctx context.Context, err error, query string, d time.Duration) bool {
+		decided = append(decided, err)
+		return !maintenance
+	})
+
+	// vetoed during the maintenance hour
+	p.setErr(errTest)
+	if _, err := db.Query("select 1"); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	db.SetMaxQueryTime(time.Millisecond)
+	p.setErr(nil)
+	p.setDelay(5 * time.Millisecond)
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if n := db.Stats().RetryCount; n != 0 || s.queryCount() != 0 {
+		t.Fatalf("got retry count %d and %d secondary queries", n, s.queryCount())
+	}
+	if len(decided) != 2 || decided[0] != errTest {
+		t.Fatalf("got decisions %v", decided)
+	}
+
+	// the decider overrides the classifier
+	maintenance = false
+	p.setDelay(0)
+	db.SetPrimaryErrorClassifier(func(error) bool { return false })
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := db.Stats().RetryCount; n != 1 {
+		t.Fatalf("got retry count %d expected 1", n)
+	}
+}
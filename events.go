@@ -0,0 +1,99 @@
+package retrydb
+
+import (
+	"time"
+)
+
+// eventBufferSize is the capacity of the FailoverEvents channel
+const eventBufferSize = 16
+
+// FailoverReason describes why the Primary was disabled
+type FailoverReason int
+
+const (
+	ReasonNone       FailoverReason = iota
+	ReasonQueryError                // a query against the Primary errored
+	ReasonSlowQuery                 // a query against the Primary exceeded the max query time
+	ReasonReadOnly                  // an Exec against the Primary failed because it is read-only
+	ReasonManual                    // DisablePrimary was called
+	ReasonPing                      // a Ping of the Primary failed
+	numReasons
+)
+
+func (r FailoverReason) String() string {
+	switch r {
+	case ReasonQueryError:
+		return "query_error"
+	case ReasonSlowQuery:
+		return "slow_query"
+	case ReasonReadOnly:
+		return "read_only"
+	case ReasonManual:
+		return "manual"
+	case ReasonPing:
+		return "ping"
+	}
+	return "none"
+}
+
+// FailoverEventType is the transition a FailoverEvent describes
+type FailoverEventType int
+
+const (
+	PrimaryDisabled FailoverEventType = iota // queries moved to the Secondary
+	PrimaryEnabled                           // queries returned to the Primary
+)
+
+func (t FailoverEventType) String() string {
+	if t == PrimaryEnabled {
+		return "primary_enabled"
+	}
+	return "primary_disabled"
+}
+
+// FailoverEvent is sent on the FailoverEvents channel when the Primary is
+// disabled or re-enabled
+type FailoverEvent struct {
+	Name   string // see SetName
+	Type   FailoverEventType
+	Time   time.Time
+	Reason FailoverReason // for PrimaryDisabled events
+	Err    error          // for PrimaryDisabled events
+}
+
+// FailoverEvents returns a channel of Primary disable and re-enable
+// transitions. Events are dropped if the channel buffer is full; see
+// DBStats.DroppedEvents. The channel is closed by Close.
+func (db *RetryDB) FailoverEvents() <-chan FailoverEvent {
+	db.Lock()
+	defer db.Unlock()
+	if db.events == nil {
+		db.events = make(chan FailoverEvent, eventBufferSize)
+		if db.eventsClosed {
+			close(db.events)
+		}
+	}
+	return db.events
+}
+
+// emit sends e without blocking; db must be locked
+func (db *RetryDB) emit(e FailoverEvent) {
+	if db.events == nil || db.eventsClosed {
+		return
+	}
+	e.Name = db.name
+	select {
+	case db.events <- e:
+	default:
+		db.droppedEvents.Add(1)
+	}
+}
+
+func (db *RetryDB) closeEvents() {
+	db.Lock()
+	if !db.eventsClosed && db.events != nil {
+		close(db.events)
+	}
+	db.eventsClosed = true
+	db.Unlock()
+}
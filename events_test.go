@@ -0,0 +1,40 @@
+package retrydb
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFailoverEvents(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	events := db.FailoverEvents()
+
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	e := <-events
+	if e.Type != PrimaryDisabled || e.Reason != ReasonQueryError || e.Err == nil {
+		t.Fatalf("got %+v", e)
+	}
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	if e := <-events; e.Type != PrimaryEnabled {
+		t.Fatalf("got %+v", e)
+	}
+
+	// events are dropped when the consumer is not keeping up
+	for i := 0; i < eventBufferSize+2; i++ {
+		db.updateRetry(context.Background(), ReasonQueryError, errTest)
+		db.updateRetry(context.Background(), ReasonNone, nil)
+	}
+	if n := db.Stats().DroppedEvents; n != eventBufferSize+4 {
+		t.Fatalf("got %d dropped events expected %d", n, eventBufferSize+4)
+	}
+
+	db.Close()
+	var n int
+	for range events {
+		n++
+	}
+	if n != eventBufferSize {
+		t.Fatalf("got %d buffered events expected %d", n, eventBufferSize)
+	}
+}
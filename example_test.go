@@ -1,43 +1,110 @@
 package retrydb_test
 
 import (
-	"flag"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/jehiah/retrydb"
 )
 
-func Example() {
-	primaryConn := flag.String("primary", "", "the DSN for primary db connection")
-	secondaryConn := flag.String("secondary", "", "the DSN for primary db connection")
-	flag.Parse()
+// exampleDriver is a minimal in-memory database/sql driver. Every query
+// returns one row holding the DSN of the server, so the output shows which
+// endpoint served it, unless the server has been marked down.
+type exampleDriver struct{}
 
-	db, err := retrydb.Open("mysql", *primaryConn, "mysql", *secondaryConn)
-	if err != nil {
-		log.Fatal(err)
+var downServers sync.Map // dsn -> true
+
+func init() {
+	sql.Register("example", exampleDriver{})
+}
+
+func (exampleDriver) Open(dsn string) (driver.Conn, error) { return exampleConn(dsn), nil }
+
+type exampleConn string
+
+func (c exampleConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("example: prepare not supported")
+}
+
+func (c exampleConn) Close() error { return nil }
+
+func (c exampleConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("example: transactions not supported")
+}
+
+func (c exampleConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if _, down := downServers.Load(string(c)); down {
+		return nil, errors.New("connection refused")
 	}
-	defer db.Close()
+	return &exampleRows{name: string(c)}, nil
+}
 
-	err = db.Ping()
-	if err != nil {
-		log.Printf("ping failed %s", err)
+type exampleRows struct {
+	name string
+	done bool
+}
+
+func (r *exampleRows) Columns() []string { return []string{"name"} }
+func (r *exampleRows) Close() error      { return nil }
+
+func (r *exampleRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
 	}
+	dest[0], r.done = r.name, true
+	return nil
+}
 
-	var k string
-	rows, err := db.Query("select k from table_t where k = ?", "k")
+// This example shows a failover and recovery end to end using the in-memory
+// driver above in place of a real database driver such as MySQL.
+func Example() {
+	db, err := retrydb.Open("example", "example-primary", "example", "example-secondary")
 	if err != nil {
-		log.Fatalf("query failed %s", err)
+		log.Fatal(err)
 	}
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&k)
-		if err != nil {
+	defer db.Close()
+	db.SetLogger(log.New(io.Discard, "", 0))
+	// disable the Primary for a minute after it fails
+	db.SetRetryStrategy(func(uint32) time.Duration { return time.Minute })
+
+	query := func() {
+		var name string
+		row, source := db.QueryRowServedBy("select name")
+		if err := row.Scan(&name); err != nil {
 			log.Fatal(err)
 		}
+		fmt.Printf("%s served by %s\n", name, source)
+	}
+
+	query()
+
+	// the Primary fails; the query is retried against the Secondary, and
+	// queries stay there while the Primary is disabled
+	downServers.Store("example-primary", true)
+	query()
+	downServers.Delete("example-primary")
+	query()
+	fmt.Println("primary disabled:", db.Stats().RetryCount > 0)
+
+	// once the Primary is reachable it can be re-enabled early
+	if err := db.ReconnectPrimary(context.Background()); err != nil {
+		log.Fatal(err)
 	}
-	log.Printf("Query got k:%q error:%v", k, err)
+	query()
+	fmt.Println("primary disabled:", db.Stats().RetryCount > 0)
 
-	k = ""
-	err = db.QueryRow("select k from table_t where k = ?", "k").Scan(&k)
-	log.Printf("QueryRow got k:%q error:%v", k, err)
+	// Output:
+	// example-primary served by primary
+	// example-secondary served by secondary
+	// example-secondary served by secondary
+	// primary disabled: true
+	// example-primary served by primary
+	// primary disabled: false
 }
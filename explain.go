@@ -0,0 +1,71 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// SetCaptureExplain runs EXPLAIN for a query that triggers a slow query
+// failover and passes the plan to f. EXPLAIN runs in the background against
+// the Secondary so it adds no load to the Primary and never delays the query.
+// Each plan row is a line with its columns separated by tabs. Capture is best
+// effort; plans that fail are dropped.
+func (db *RetryDB) SetCaptureExplain(capture bool, f func(query, plan string)) {
+	db.Lock()
+	if capture && f != nil {
+		db.onExplain = f
+	} else {
+		db.onExplain = nil
+	}
+	db.Unlock()
+}
+
+// maybeExplain captures the plan for a slow query when enabled
+func (db *RetryDB) maybeExplain(query string, args []interface{}) {
+	db.RLock()
+	f, timeout := db.onExplain, db.maxQueryTime
+	db.RUnlock()
+	if f == nil || !db.secondaryActive() {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(db.ctx, timeout)
+		defer cancel()
+		rows, err := queryContext(ctx, db.pickSecondary(), "EXPLAIN "+query, args...)
+		if err != nil {
+			return
+		}
+		plan, err := readPlan(rows)
+		if err != nil {
+			return
+		}
+		db.callback(ctx, "explain", func() { f(query, plan) })
+	}()
+}
+
+// readPlan reads and closes rows as lines of tab separated columns
+func readPlan(rows *sql.Rows) (string, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var lines []string
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		line := make([]string, len(values))
+		for i, v := range values {
+			line[i] = v.String
+		}
+		lines = append(lines, strings.Join(line, "\t"))
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
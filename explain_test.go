@@ -0,0 +1,45 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptureExplain(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetMaxQueryTime(time.Millisecond)
+	p.setDelay(5 * time.Millisecond)
+	s.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if !strings.HasPrefix(query, "EXPLAIN ") {
+			return []string{"source"}, [][]driver.Value{{s.name}}, nil
+		}
+		return []string{"id", "table", "key"}, [][]driver.Value{
+			{int64(1), "users", "PRIMARY"},
+			{int64(2), "orders", nil},
+		}, nil
+	})
+	type explain struct{ query, plan string }
+	plans := make(chan explain, 1)
+	db.SetCaptureExplain(true, func(query, plan string) { plans <- explain{query, plan} })
+
+	if v := queryValue(t, db, "select * from users where id = ?", 1); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	select {
+	case e := <-plans:
+		if e.query != "select * from users where id = ?" || e.plan != "1\tusers\tPRIMARY\n2\torders\t" {
+			t.Fatalf("got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for plan")
+	}
+	if q := s.queryAt(0); q != "EXPLAIN select * from users where id = ?" {
+		t.Fatalf("got secondary query %q", q)
+	}
+	if n := p.queryCount(); n != 1 {
+		t.Fatalf("got %d primary queries expected 1", n)
+	}
+}
@@ -0,0 +1,134 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type hedgeResult struct {
+	rows   *sql.Rows
+	source Source
+	err    error
+}
+
+// QueryFastest sends query to both the Primary and the Secondary concurrently
+// and returns the rows from whichever succeeds first along with the Source
+// that served them. The slower query is cancelled and any rows it returns are
+// closed. If both fail the Primary error is returned. Without a Secondary it
+// queries only the Primary. QueryFastest bypasses the failover window.
+func (db *RetryDB) QueryFastest(ctx context.Context, query string, args ...interface{}) (*sql.Rows, Source, error) {
+	if err := db.validateArgs(query, args); err != nil {
+		return nil, SourceNone, err
+	}
+	if !db.secondaryActive() {
+		rows, err := db.primaryQueryRetry(ctx, query, args...)
+		return rows, SourcePrimary, err
+	}
+
+	rows, source, _, err := db.hedge(ctx, 0, query, args)
+	return rows, source, err
+}
+
+// SetHedgeDelay makes Primary queries from QueryContext that have not
+// responded within d also start the query against the Secondary, returning
+// whichever succeeds first. The Secondary is never queried when the Primary
+// responds within d. A d of 0 disables hedging.
+func (db *RetryDB) SetHedgeDelay(d time.Duration) {
+	db.Lock()
+	db.hedgeDelay = d
+	db.Unlock()
+}
+
+// hedge queries the Primary, also querying the Secondary once delay passes
+// without a Primary response, and returns the first success. The slower query
+// is cancelled and any rows it returns are closed. If both fail the Primary
+// error is returned. primaryErr is the error of a Primary query that failed
+// before the Secondary succeeded.
+func (db *RetryDB) hedge(ctx context.Context, delay time.Duration, query string, args []interface{}) (rows *sql.Rows, source Source, primaryErr, err error) {
+	ctx, closers := withRowsClosers(ctx)
+	results := make(chan hedgeResult, 2)
+	cancels := make(map[Source]context.CancelFunc, 2)
+	launch := func(source Source) {
+		qctx, cancel := context.WithCancel(ctx)
+		cancels[source] = cancel
+		go func() {
+			results <- db.hedgeQuery(qctx, source, query, args)
+		}()
+	}
+
+	launch(SourcePrimary)
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case r := <-results:
+			closers.onClose(r.rows, cancels[SourcePrimary])
+			return r.rows, r.source, nil, r.err
+		case <-timer.C:
+		}
+	}
+	launch(SourceSecondary)
+
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err == nil {
+			if i == 0 {
+				loser := SourceSecondary
+				if r.source == SourceSecondary {
+					loser = SourcePrimary
+				}
+				cancels[loser]()
+				go closeResult(results)
+			}
+			// the winning context must outlive the returned rows so it is
+			// only released once they are closed
+			closers.onClose(r.rows, cancels[r.source])
+			return r.rows, r.source, primaryErr, nil
+		}
+		cancels[r.source]()
+		if r.source == SourcePrimary {
+			primaryErr = r.err
+		}
+	}
+	return nil, SourcePrimary, nil, primaryErr
+}
+
+// hedgeQuery runs query against source with retries (see retryQuery),
+// cancelled by Shutdown while pending
+func (db *RetryDB) hedgeQuery(ctx context.Context, source Source, query string, args []interface{}) hedgeResult {
+	if db.ctx != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		stop := context.AfterFunc(db.ctx, cancel)
+		defer stop()
+	}
+	var rows *sql.Rows
+	var err error
+	if source == SourcePrimary {
+		rows, err = db.primaryQueryRetry(ctx, query, args...)
+	} else {
+		rows, err = db.retryQuery(ctx, SourceSecondary, db.secondaryQuery, query, args...)
+	}
+	return hedgeResult{rows, source, err}
+}
+
+// hedgedPrimaryError opens the failover window for a Primary query that
+// failed while the Secondary answered a hedged query
+func (db *RetryDB) hedgedPrimaryError(ctx context.Context, err error, query string, args []interface{}, d time.Duration) {
+	ferr, stands := db.primaryError(ctx, err, nil, query, d)
+	if stands || ferr == nil {
+		return
+	}
+	if db.recordPrimaryOutcome(true) {
+		db.updateRetry(ctx, ReasonQueryError, fmt.Errorf("hedged query errored with %s. answered by secondary. sql:%s", ferr, db.formatQuery(query, args)))
+	}
+}
+
+// closeResult closes the rows of the next result on results
+func closeResult(results <-chan hedgeResult) {
+	if r := <-results; r.rows != nil {
+		r.rows.Close()
+	}
+}
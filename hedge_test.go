@@ -0,0 +1,150 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestQueryFastest(t *testing.T) {
+	db, p, s := newTestDB(t)
+	ctx := context.Background()
+
+	p.setDelay(time.Second)
+	start := time.Now()
+	rows, source, err := db.QueryFastest(ctx, "select 1")
+	if v := scanValue(t, rows, err); v != s.name || source != SourceSecondary {
+		t.Fatalf("got %q from %s expected %q", v, source, s.name)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("query took %s", d)
+	}
+
+	p.setDelay(0)
+	s.setDelay(time.Second)
+	rows, source, err = db.QueryFastest(ctx, "select 1")
+	if v := scanValue(t, rows, err); v != p.name || source != SourcePrimary {
+		t.Fatalf("got %q from %s expected %q", v, source, p.name)
+	}
+
+	// an error from the faster endpoint waits for the slower one
+	p.setErr(errTest)
+	s.setDelay(10 * time.Millisecond)
+	rows, source, err = db.QueryFastest(ctx, "select 1")
+	if v := scanValue(t, rows, err); v != s.name || source != SourceSecondary {
+		t.Fatalf("got %q from %s expected %q", v, source, s.name)
+	}
+	s.setErr(errTest)
+	if _, _, err := db.QueryFastest(ctx, "select 1"); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+}
+
+func TestHedgeDelay(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetHedgeDelay(20 * time.Millisecond)
+
+	// a fast primary never launches the secondary
+	p.setDelay(5 * time.Millisecond)
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if n := s.queryCount(); n != 0 {
+		t.Fatalf("got %d secondary queries expected 0", n)
+	}
+
+	p.setDelay(time.Second)
+	start := time.Now()
+	rows, info, err := db.QueryWithInfo(context.Background(), "select 1")
+	if v := scanValue(t, rows, err); v != s.name || info.Source != SourceSecondary || info.Failover {
+		t.Fatalf("got %q with %+v expected %q", v, info, s.name)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("query took %s", d)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+}
+
+func TestHedgeDelayRetries(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetHedgeDelay(time.Second)
+	db.SetConnRetries(2, 0)
+	var attempts atomic.Int32
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if attempts.Add(1) == 1 {
+			return nil, nil, &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+		}
+		return []string{"source"}, [][]driver.Value{{p.name}}, nil
+	})
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if n := attempts.Load(); n != 2 || s.queryCount() != 0 || db.Stats().RetryCount != 0 {
+		t.Fatalf("got %d attempts, %d secondary queries, stats %+v", n, s.queryCount(), db.Stats())
+	}
+
+	// a primary error while the secondary answers opens the failover window
+	db.SetHedgeDelay(5 * time.Millisecond)
+	p.setHandler(nil)
+	p.setErr(errTest)
+	p.setDelay(20 * time.Millisecond)
+	s.setDelay(100 * time.Millisecond)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := db.Stats().RetryCount; n != 1 {
+		t.Fatalf("got retry count %d expected 1", n)
+	}
+}
+
+func TestHedgeReleasesContext(t *testing.T) {
+	db, p, s := newTestDB(t)
+	ctxs := make(chan context.Context, 2)
+	handler := func(name string) fakeHandler {
+		return func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+			if ctx.Err() != nil {
+				// a loser of an earlier query
+				return nil, nil, ctx.Err()
+			}
+			ctxs <- ctx
+			return []string{"source"}, [][]driver.Value{{name}}, nil
+		}
+	}
+	check := func(rows *sql.Rows, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := <-ctxs
+		if ctx.Err() != nil {
+			t.Fatal("the winning query was cancelled before its rows were closed")
+		}
+		rows.Close()
+		if ctx.Err() == nil {
+			t.Fatal("the winning query context was not released when its rows were closed")
+		}
+	}
+
+	// QueryFastest won by the secondary
+	p.setDelay(time.Second)
+	s.setHandler(handler(s.name))
+	rows, _, err := db.QueryFastest(context.Background(), "select 1")
+	check(rows, err)
+
+	// a hedged query answered by the primary before the delay
+	p.setDelay(0)
+	p.setHandler(handler(p.name))
+	db.SetHedgeDelay(time.Second)
+	rows, err = db.QueryContext(context.Background(), "select 1")
+	check(rows, err)
+}
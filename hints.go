@@ -0,0 +1,41 @@
+package retrydb
+
+import (
+	"strings"
+)
+
+// SetHintRouting enables routing hints in a leading SQL comment.
+// "/* route:primary */" sends the query to the Primary as with ForcePrimary
+// and "/* route:secondary */" sends it to the Secondary. Queries without a
+// hint, or with an unrecognized one, are routed as usual.
+func (db *RetryDB) SetHintRouting(enabled bool) {
+	db.Lock()
+	db.hintRouting = enabled
+	db.Unlock()
+}
+
+// routeHint returns the Source named by a leading routing hint in query, or
+// SourceNone
+func (db *RetryDB) routeHint(query string) Source {
+	db.RLock()
+	enabled := db.hintRouting
+	db.RUnlock()
+	if !enabled {
+		return SourceNone
+	}
+	query = strings.TrimLeft(query, " \t\r\n")
+	if !strings.HasPrefix(query, "/*") {
+		return SourceNone
+	}
+	end := strings.Index(query, "*/")
+	if end == -1 {
+		return SourceNone
+	}
+	switch strings.TrimSpace(query[2:end]) {
+	case "route:primary":
+		return SourcePrimary
+	case "route:secondary":
+		return SourceSecondary
+	}
+	return SourceNone
+}
@@ -0,0 +1,37 @@
+package retrydb
+
+import (
+	"testing"
+)
+
+func TestHintRouting(t *testing.T) {
+	db, p, s := newTestDB(t)
+	for _, tc := range []struct {
+		query    string
+		expected *fakeServer
+	}{
+		{"select 1", p},
+		{"/* route:primary */ select 1", p},
+		{"/* route:secondary */ select 1", s},
+		{"  /*route:secondary*/ select 1", s},
+		{"/* route:tertiary */ select 1", p},
+		{"select /* route:secondary */ 1", p},
+		{"/* route:secondary select 1", p},
+	} {
+		db.SetHintRouting(false)
+		if v := queryValue(t, db, tc.query); v != p.name {
+			t.Fatalf("%q without hint routing got %q", tc.query, v)
+		}
+		db.SetHintRouting(true)
+		if v := queryValue(t, db, tc.query); v != tc.expected.name {
+			t.Fatalf("%q got %q expected %q", tc.query, v, tc.expected.name)
+		}
+	}
+
+	// a primary hint ignores the failover window
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	if _, err := db.Query("/* route:primary */ select 1"); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+}
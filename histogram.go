@@ -0,0 +1,68 @@
+package retrydb
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets is the number of exponential buckets; bucket i counts
+// latencies up to 2^i microseconds (the last bucket is unbounded)
+const latencyBuckets = 32
+
+// LatencyStats summarizes the latency of queries against one endpoint.
+// Percentiles are approximate; they report the upper bound of the
+// exponential bucket the percentile falls into.
+type LatencyStats struct {
+	Count uint64        `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+}
+
+type latencyHistogram struct {
+	buckets [latencyBuckets]atomic.Uint64
+}
+
+func latencyBucket(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	i := bits.Len64(uint64((d - 1) / time.Microsecond))
+	if i >= latencyBuckets {
+		return latencyBuckets - 1
+	}
+	return i
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	h.buckets[latencyBucket(d)].Add(1)
+}
+
+func (h *latencyHistogram) stats() (s LatencyStats) {
+	var counts [latencyBuckets]uint64
+	for i := range h.buckets {
+		counts[i] = h.buckets[i].Load()
+		s.Count += counts[i]
+	}
+	if s.Count == 0 {
+		return
+	}
+	s.P50 = percentile(counts, s.Count, 0.50)
+	s.P95 = percentile(counts, s.Count, 0.95)
+	s.P99 = percentile(counts, s.Count, 0.99)
+	return
+}
+
+func percentile(counts [latencyBuckets]uint64, total uint64, p float64) time.Duration {
+	rank := uint64(math.Ceil(p * float64(total)))
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen >= rank {
+			return time.Duration(1<<uint(i)) * time.Microsecond
+		}
+	}
+	return time.Duration(1<<uint(latencyBuckets-1)) * time.Microsecond
+}
@@ -0,0 +1,40 @@
+package retrydb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	for i := 0; i < 90; i++ {
+		h.observe(time.Millisecond)
+	}
+	for i := 0; i < 8; i++ {
+		h.observe(100 * time.Millisecond)
+	}
+	for i := 0; i < 2; i++ {
+		h.observe(2 * time.Second)
+	}
+	s := h.stats()
+	expected := LatencyStats{
+		Count: 100,
+		P50:   1024 * time.Microsecond,
+		P95:   131072 * time.Microsecond,
+		P99:   2097152 * time.Microsecond,
+	}
+	if s != expected {
+		t.Fatalf("got %+v expected %+v", s, expected)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	queryValue(t, db, "select 1")
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	s := db.Stats()
+	if s.PrimaryLatency.Count != 2 || s.SecondaryLatency.Count != 1 {
+		t.Fatalf("got primary %+v secondary %+v", s.PrimaryLatency, s.SecondaryLatency)
+	}
+}
@@ -0,0 +1,38 @@
+package retrydb
+
+import (
+	"math/rand"
+	"time"
+)
+
+// SetRetryJitter randomly shortens each failover window by up to fraction
+// (0 to 1) of the interval from the retry strategy, so several RetryDBs that
+// failed together do not all probe the Primary at the same moment.
+// NextRetryInterval reports the interval before jitter.
+func (db *RetryDB) SetRetryJitter(fraction float64) {
+	db.Lock()
+	db.retryJitter = fraction
+	db.Unlock()
+}
+
+// SetRand sets the random source used for retry jitter, for example a seeded
+// source for deterministic tests. The default is the randomly seeded
+// math/rand top level source.
+func (db *RetryDB) SetRand(r *rand.Rand) {
+	db.Lock()
+	db.rand = r
+	db.Unlock()
+}
+
+// jitter applies the retry jitter to d; db must be write locked since a
+// *rand.Rand is not safe for concurrent use
+func (db *RetryDB) jitter(d time.Duration) time.Duration {
+	if db.retryJitter <= 0 || d <= 0 {
+		return d
+	}
+	f := rand.Float64
+	if db.rand != nil {
+		f = db.rand.Float64
+	}
+	return d - time.Duration(float64(d)*db.retryJitter*f())
+}
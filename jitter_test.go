@@ -0,0 +1,44 @@
+package retrydb
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestRetryJitter(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	db.SetRetryJitter(0.5)
+	intervals := func(seed int64) []time.Duration {
+		db.SetRand(rand.New(rand.NewSource(seed)))
+		db.Lock()
+		defer db.Unlock()
+		var got []time.Duration
+		for i := 0; i < 5; i++ {
+			d := db.jitter(time.Minute)
+			if d < 30*time.Second || d > time.Minute {
+				t.Fatalf("got interval %s outside the jitter range", d)
+			}
+			got = append(got, d)
+		}
+		return got
+	}
+	a, b := intervals(1), intervals(1)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("got %v and %v with the same seed", a, b)
+		}
+	}
+
+	// the jittered interval is applied to the failover window
+	db.SetRand(rand.New(rand.NewSource(1)))
+	start := time.Now()
+	db.updateRetry(context.Background(), ReasonQueryError, errTest)
+	db.RLock()
+	d := db.retryUntil.Sub(start)
+	db.RUnlock()
+	if d < a[0] || d > a[0]+100*time.Millisecond {
+		t.Fatalf("got window %s expected %s", d, a[0])
+	}
+}
@@ -0,0 +1,147 @@
+package retrydb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Logger is the interface used to log failover events; *log.Logger implements it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// SetLogger sets the Logger used for failover events. The default is the
+// standard library logger.
+func (db *RetryDB) SetLogger(l Logger) {
+	db.Lock()
+	db.log = l
+	db.Unlock()
+}
+
+// SetLoggerContextKey sets a context key that QueryContext uses to find a
+// request scoped Logger. When the context passed to QueryContext has a Logger
+// stored under key, failover events triggered by that query are logged to it
+// instead of the RetryDB Logger.
+func (db *RetryDB) SetLoggerContextKey(key interface{}) {
+	db.Lock()
+	db.logContextKey = key
+	db.Unlock()
+}
+
+// SetName sets a name identifying db in log messages and FailoverEvents, for
+// services with several RetryDBs. Log messages are prefixed with "name: ".
+func (db *RetryDB) SetName(name string) {
+	db.Lock()
+	db.name = name
+	db.Unlock()
+}
+
+// Name returns the name set with SetName
+func (db *RetryDB) Name() string {
+	db.RLock()
+	defer db.RUnlock()
+	return db.name
+}
+
+// errCallbackPanic is used in place of the error from a user supplied func
+// that panicked
+var errCallbackPanic = errors.New("retrydb: callback panicked")
+
+// callback runs the user supplied callback f, logging a panic from it instead
+// of crashing the query that triggered it
+func (db *RetryDB) callback(ctx context.Context, name string, f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			db.logger(ctx).Printf("%s callback panicked: %v", name, r)
+		}
+	}()
+	f()
+}
+
+// logger returns the Logger for events triggered within ctx
+func (db *RetryDB) logger(ctx context.Context) Logger {
+	db.RLock()
+	l, key, name := db.log, db.logContextKey, db.name
+	db.RUnlock()
+	if key != nil {
+		if cl, ok := ctx.Value(key).(Logger); ok {
+			l = cl
+		}
+	}
+	if name != "" {
+		return prefixLogger{l, name}
+	}
+	return l
+}
+
+// prefixLogger prefixes messages with the RetryDB name
+type prefixLogger struct {
+	Logger
+	name string
+}
+
+func (l prefixLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Printf("%s: %s", l.name, fmt.Sprintf(format, v...))
+}
+
+// SetQueryLogger sets the function used to format a query and its args in
+// failover log messages, for example to include args or redact sensitive
+// values. By default only the quoted query is logged.
+func (db *RetryDB) SetQueryLogger(f func(query string, args []interface{}) string) {
+	db.Lock()
+	db.queryLogger = f
+	db.Unlock()
+}
+
+func (db *RetryDB) formatQuery(query string, args []interface{}) string {
+	db.RLock()
+	f, normalize := db.queryLogger, db.normalizeQueries
+	db.RUnlock()
+	if f == nil {
+		if normalize {
+			query = normalizeQuery(query)
+		}
+		return fmt.Sprintf("%q", query)
+	}
+	formatted := fmt.Sprintf("%q", query)
+	db.callback(context.Background(), "query logger", func() {
+		formatted = f(query, args)
+	})
+	return formatted
+}
+
+// SetLogThrottle limits "updating master disabled" messages, logged each time
+// a query fails while the Primary is already disabled, to one per interval.
+// Suppressed messages are summarized in the next logged message. Zero (the
+// default) logs every message.
+func (db *RetryDB) SetLogThrottle(interval time.Duration) {
+	db.Lock()
+	db.logThrottle = interval
+	db.Unlock()
+}
+
+// throttleLog returns true if a failover extension message should be logged
+// at now; db must be locked
+func (db *RetryDB) throttleLog(logger Logger, now time.Time) bool {
+	if db.logThrottle <= 0 {
+		return true
+	}
+	if now.Sub(db.lastExtendLog) < db.logThrottle {
+		db.suppressedLogs++
+		return false
+	}
+	db.flushThrottledLogs(logger, now)
+	db.lastExtendLog = now
+	return true
+}
+
+// flushThrottledLogs logs a summary of suppressed messages; db must be locked
+func (db *RetryDB) flushThrottledLogs(logger Logger, now time.Time) {
+	if db.suppressedLogs == 0 {
+		return
+	}
+	logger.Printf("%d failover updates in last %s", db.suppressedLogs, now.Sub(db.lastExtendLog).Round(time.Second))
+	db.suppressedLogs = 0
+}
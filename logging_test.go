@@ -0,0 +1,178 @@
+package retrydb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+type logKey struct{}
+
+func TestLoggerContextKey(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	var global, request bytes.Buffer
+	db.SetLogger(log.New(&global, "", 0))
+	db.SetLoggerContextKey(logKey{})
+
+	p.setErr(errTest)
+	ctx := context.WithValue(context.Background(), logKey{}, log.New(&request, "request_id=1 ", 0))
+	rows, err := db.QueryContext(ctx, "select 1")
+	scanValue(t, rows, err)
+	if !strings.HasPrefix(request.String(), "request_id=1 disabling master until") {
+		t.Fatalf("got request log %q", request.String())
+	}
+	if global.Len() != 0 {
+		t.Fatalf("got global log %q", global.String())
+	}
+
+	// without a request scoped logger the RetryDB logger is used
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	if !strings.HasPrefix(global.String(), "re-enabling master") {
+		t.Fatalf("got global log %q", global.String())
+	}
+}
+
+func TestQueryLogger(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	var buf bytes.Buffer
+	db.SetLogger(log.New(&buf, "", 0))
+	p.setErr(errTest)
+	queryValue(t, db, "select * from users where email = ?", "user@example.com")
+	if !strings.HasSuffix(buf.String(), `sql:"select * from users where email = ?"`+"\n") {
+		t.Fatalf("got %q", buf.String())
+	}
+
+	buf.Reset()
+	db.SetQueryLogger(func(query string, args []interface{}) string {
+		return fmt.Sprintf("%q args:%d redacted", query, len(args))
+	})
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	queryValue(t, db, "select * from users where email = ?", "user@example.com")
+	if !strings.HasSuffix(buf.String(), `sql:"select * from users where email = ?" args:1 redacted`+"\n") {
+		t.Fatalf("got %q", buf.String())
+	}
+}
+
+func TestSetName(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	var buf bytes.Buffer
+	db.SetLogger(log.New(&buf, "", 0))
+	db.SetName("shard-3")
+	events := db.FailoverEvents()
+
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	if !strings.HasPrefix(buf.String(), "shard-3: disabling master until") {
+		t.Fatalf("got log %q", buf.String())
+	}
+	if e := <-events; e.Name != "shard-3" {
+		t.Fatalf("got event name %q", e.Name)
+	}
+
+	buf.Reset()
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	if !strings.HasPrefix(buf.String(), "shard-3: re-enabling master") {
+		t.Fatalf("got log %q", buf.String())
+	}
+}
+
+func TestLogThrottle(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	var buf bytes.Buffer
+	db.SetLogger(log.New(&buf, "", 0))
+	db.SetLogThrottle(time.Minute)
+
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	for i := 0; i < 5; i++ {
+		db.updateRetry(context.Background(), ReasonQueryError, errTest)
+	}
+	if n := strings.Count(buf.String(), "updating master disabled"); n != 1 {
+		t.Fatalf("got %d extension messages expected 1: %q", n, buf.String())
+	}
+
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "4 failover updates in last") {
+		t.Fatalf("got %q", lines)
+	}
+}
+
+func TestCallbackPanics(t *testing.T) {
+	db, p, s := newTestDB(t)
+	var buf bytes.Buffer
+	db.SetLogger(log.New(&buf, "", 0))
+	db.SetOnQuery(func(query string, source Source, d time.Duration, err error) { panic("boom") })
+	db.SetOnProbe(func(success bool, err error) { panic("boom") })
+	db.SetQueryLogger(func(query string, args []interface{}) string { panic("boom") })
+	db.SetRetryStrategy(func(uint32) time.Duration { return 0 })
+
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	p.setErr(nil)
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	for _, name := range []string{"OnQuery", "OnProbe", "query logger"} {
+		if !strings.Contains(buf.String(), name+" callback panicked: boom") {
+			t.Fatalf("missing %s panic in log %q", name, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), `sql:"select 1"`) {
+		t.Fatalf("got log %q", buf.String())
+	}
+}
+
+func TestHookPanics(t *testing.T) {
+	db, p, s := newTestDB(t)
+	var buf bytes.Buffer
+	db.SetLogger(log.New(&buf, "", 0))
+	boom := func(error) bool { panic("boom") }
+	db.SetPrimaryErrorClassifier(boom)
+	db.SetDeadlockRetry(1)
+	db.SetDeadlockClassifier(boom)
+	db.SetFailoverDecider(func(ctx context.Context, err error, query string, d time.Duration) bool { panic("boom") })
+	ctx := context.Background()
+
+	// the default classifier decides
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	p.setErr(nil)
+	if err := db.ReconnectPrimary(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the replica checks fail
+	db.SetMaxReplicaLag(time.Second, func(context.Context, Retryable) (time.Duration, error) { panic("boom") })
+	db.SetReplicaTimestampFunc(func(context.Context, Retryable) (time.Time, error) { panic("boom") })
+	db.SetReplicaWaitFunc(func(ctx context.Context, conn *sql.Conn) error { panic("boom") })
+	db.SetReadPreference(SecondaryOnly)
+	if _, err := db.QueryContext(ctx, "select 1"); !errors.Is(err, errCallbackPanic) {
+		t.Fatalf("got %v expected %v", err, errCallbackPanic)
+	}
+	db.SetMaxReplicaLag(0, nil)
+	if _, err := db.QueryContext(WithConsistencyToken(ctx, "t"), "select 1"); !errors.Is(err, errCallbackPanic) {
+		t.Fatalf("got %v expected %v", err, errCallbackPanic)
+	}
+	db.SetReadPreference(SecondaryPreferred)
+	rows, err := db.QueryContext(WithMaxStaleness(ctx, time.Hour), "select 1")
+	if v := scanValue(t, rows, err); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+
+	for _, name := range []string{"error classifier", "deadlock classifier", "failover decider", "replica lag", "replica wait", "replica timestamp"} {
+		if !strings.Contains(buf.String(), name+" callback panicked: boom") {
+			t.Fatalf("missing %s panic in log %q", name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// NamedStat contains statistics for a query registered with Register
+type NamedStat struct {
+	Executions uint64        `json:"executions"`
+	Failovers  uint64        `json:"failovers"`
+	Errors     uint64        `json:"errors"`
+	AvgLatency time.Duration `json:"avg_latency"`
+}
+
+type namedQuery struct {
+	query      string
+	executions atomic.Uint64
+	failovers  atomic.Uint64
+	errors     atomic.Uint64
+	latency    atomic.Int64 // total
+}
+
+// Register stores query under name for use with QueryNamed
+func (db *RetryDB) Register(name, query string) {
+	db.Lock()
+	if db.named == nil {
+		db.named = make(map[string]*namedQuery)
+	}
+	db.named[name] = &namedQuery{query: query}
+	db.Unlock()
+}
+
+// QueryNamed runs the query registered under name with the same failover
+// behavior as QueryContext
+func (db *RetryDB) QueryNamed(ctx context.Context, name string, args ...interface{}) (*sql.Rows, error) {
+	db.RLock()
+	n, ok := db.named[name]
+	db.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("retrydb: unknown named query %q", name)
+	}
+	rows, info, err := db.linkedQuery(ctx, n.query, args...)
+	n.executions.Add(1)
+	n.latency.Add(int64(info.Duration))
+	if info.Failover {
+		n.failovers.Add(1)
+	}
+	if getFatalError(err, rows) != nil {
+		n.errors.Add(1)
+	}
+	return rows, err
+}
+
+// NamedStats returns statistics for each query registered with Register
+func (db *RetryDB) NamedStats() map[string]NamedStat {
+	db.RLock()
+	defer db.RUnlock()
+	stats := make(map[string]NamedStat, len(db.named))
+	for name, n := range db.named {
+		s := NamedStat{
+			Executions: n.executions.Load(),
+			Failovers:  n.failovers.Load(),
+			Errors:     n.errors.Load(),
+		}
+		if s.Executions > 0 {
+			s.AvgLatency = time.Duration(n.latency.Load() / int64(s.Executions))
+		}
+		stats[name] = s
+	}
+	return stats
+}
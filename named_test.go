@@ -0,0 +1,55 @@
+package retrydb
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQueryNamed(t *testing.T) {
+	db, p, s := newTestDB(t)
+	ctx := context.Background()
+	db.Register("get_user", "select * from users where id = ?")
+
+	rows, err := db.QueryNamed(ctx, "get_user", 1)
+	if v := scanValue(t, rows, err); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if q := p.queryAt(0); q != "select * from users where id = ?" {
+		t.Fatalf("got query %q", q)
+	}
+
+	p.setErr(errTest)
+	rows, err = db.QueryNamed(ctx, "get_user", 1)
+	if v := scanValue(t, rows, err); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+
+	if _, err := db.QueryNamed(ctx, "missing"); err == nil {
+		t.Fatal("expected error for unknown name")
+	}
+}
+
+func TestNamedStats(t *testing.T) {
+	db, p, s := newTestDB(t)
+	ctx := context.Background()
+	db.Register("a", "select a")
+	db.Register("b", "select b")
+
+	for i := 0; i < 2; i++ {
+		rows, err := db.QueryNamed(ctx, "a")
+		scanValue(t, rows, err)
+	}
+	p.setErr(errTest)
+	rows, err := db.QueryNamed(ctx, "b")
+	scanValue(t, rows, err)
+	s.setErr(errTest)
+	db.QueryNamed(ctx, "b")
+
+	stats := db.NamedStats()
+	if a := stats["a"]; a.Executions != 2 || a.Failovers != 0 || a.Errors != 0 {
+		t.Fatalf("got a %+v", a)
+	}
+	if b := stats["b"]; b.Executions != 2 || b.Failovers != 1 || b.Errors != 1 || b.AvgLatency <= 0 {
+		t.Fatalf("got b %+v", b)
+	}
+}
@@ -0,0 +1,50 @@
+package retrydb
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RetryableDB adapts a RetryDB to the Retryable interface so it can be the
+// Primary or Secondary of another RetryDB for tiered failover. RetryDB itself
+// can not implement Retryable since its QueryRow returns a *Row.
+type RetryableDB struct {
+	*RetryDB
+}
+
+var _ Retryable = RetryableDB{}
+
+// QueryRow runs query against the currently active endpoint; the Secondary
+// while the Primary is disabled, otherwise the Primary. Unlike Query it does
+// not fail over, since a *sql.Row defers errors until Scan.
+func (db RetryableDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	if db.secondaryActive() && db.retrying(time.Now()) {
+		return db.Secondary.QueryRow(query, args...)
+	}
+	return db.Primary.QueryRow(query, args...)
+}
+
+// OpenChain opens a RetryDB that reads from each dsn in order, moving to the
+// next endpoint when the previous one errors, with an independent failover
+// window per hop. It is built from nested RetryDBs (see RetryableDB); settings
+// on the returned RetryDB apply to the first hop only. Only the first endpoint
+// accepts writes.
+func OpenChain(driverName string, dsns ...string) (*RetryDB, error) {
+	if len(dsns) == 0 {
+		return nil, errors.New("retrydb: no data source names")
+	}
+	primary, err := sql.Open(driverName, dsns[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(dsns) == 1 {
+		return newRetryDB(primary, nil), nil
+	}
+	next, err := OpenChain(driverName, dsns[1:]...)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+	return newRetryDB(primary, RetryableDB{next}), nil
+}
@@ -0,0 +1,81 @@
+package retrydb
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestNestedRetryDB(t *testing.T) {
+	inner, p, s := newTestDB(t)
+	tertiary := newFakeServer(t, "tertiary")
+	tdb, err := sql.Open(fakeDriverName, tertiary.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := OpenWithRetryable(RetryableDB{inner}, tdb)
+	outer.SetRetryStrategy(func(uint32) time.Duration { return time.Minute })
+	outer.SetLogger(log.New(io.Discard, "", 0))
+	defer outer.Close()
+
+	if v := queryValue(t, outer, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	var v string
+	if err := outer.Primary.QueryRow("select 1").Scan(&v); err != nil || v != p.name {
+		t.Fatalf("got %q %v expected %q", v, err, p.name)
+	}
+
+	// the inner RetryDB fails over without the outer one noticing
+	p.setErr(errTest)
+	if v := queryValue(t, outer, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := outer.Stats().RetryCount; n != 0 {
+		t.Fatalf("got outer retry count %d expected 0", n)
+	}
+
+	// both inner endpoints down
+	s.setErr(errTest)
+	if v := queryValue(t, outer, "select 1"); v != tertiary.name {
+		t.Fatalf("got %q expected %q", v, tertiary.name)
+	}
+}
+
+func TestOpenChain(t *testing.T) {
+	var servers []*fakeServer
+	var dsns []string
+	for _, name := range []string{"primary", "secondary", "tertiary"} {
+		s := newFakeServer(t, name)
+		servers = append(servers, s)
+		dsns = append(dsns, s.name)
+	}
+	db, err := OpenChain(fakeDriverName, dsns...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetLogger(log.New(io.Discard, "", 0))
+	db.Secondary.(RetryableDB).SetLogger(log.New(io.Discard, "", 0))
+
+	if v := queryValue(t, db, "select 1"); v != servers[0].name {
+		t.Fatalf("got %q expected %q", v, servers[0].name)
+	}
+	servers[0].setErr(errTest)
+	servers[1].setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != servers[2].name {
+		t.Fatalf("got %q expected %q", v, servers[2].name)
+	}
+
+	if _, err := db.Exec("insert into t values (1)"); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if servers[1].execCount() != 0 || servers[2].execCount() != 0 {
+		t.Fatal("writes sent past the first endpoint")
+	}
+	if _, err := OpenChain(fakeDriverName); err == nil {
+		t.Fatal("expected error without dsns")
+	}
+}
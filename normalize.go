@@ -0,0 +1,77 @@
+package retrydb
+
+import (
+	"regexp"
+	"strings"
+)
+
+// SetNormalizeQueries replaces literals in the SQL included in failover logs
+// and untagged OnQuery callbacks with ? so that queries differing only in
+// literal values share a fingerprint; see normalizeQuery. A SetQueryLogger
+// function still receives the raw SQL.
+func (db *RetryDB) SetNormalizeQueries(normalize bool) {
+	db.Lock()
+	db.normalizeQueries = normalize
+	db.Unlock()
+}
+
+var inList = regexp.MustCompile(`\( ?\?(?: ?, ?\?)* ?\)`)
+
+// normalizeQuery returns a fingerprint of query with comments removed,
+// whitespace collapsed, string and numeric literals replaced with ? and lists
+// of ? (such as IN lists) collapsed to a single (?)
+func normalizeQuery(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end == -1 {
+				i = len(query)
+			} else {
+				i += end + 3
+			}
+			space = true
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		switch {
+		case c == '\'':
+			for i++; i < len(query); i++ {
+				if query[i] == '\\' {
+					i++
+				} else if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case c >= '0' && c <= '9' && !identByte(query, i-1):
+			for i+1 < len(query) && (identByte(query, i+1) || query[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return inList.ReplaceAllString(b.String(), "(?)")
+}
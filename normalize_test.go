@@ -0,0 +1,49 @@
+package retrydb
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"SELECT * FROM t WHERE id = 1", "SELECT * FROM t WHERE id = ?"},
+		{"select *\n\tfrom t  where name = 'it''s' and x = 'a\\'b'", "select * from t where name = ? and x = ?"},
+		{"select 1.5, -2, 0x1F from t2 where col3 = 4", "select ?, -?, ? from t2 where col3 = ?"},
+		{"select * from t where id in (1, 2, 3)", "select * from t where id in (?)"},
+		{"select * from t where id IN ( ?,?, ? )", "select * from t where id IN (?)"},
+		{"select * from t where id = $1", "select * from t where id = $1"},
+		{"select /* comment */ a -- trailing\nfrom t", "select a from t"},
+		{"select a /* unterminated", "select a"},
+	}
+	for _, tc := range tests {
+		if got := normalizeQuery(tc.query); got != tc.want {
+			t.Errorf("normalizeQuery(%q) got %q expected %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeQueries(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	var buf bytes.Buffer
+	db.SetLogger(log.New(&buf, "", 0))
+	db.SetNormalizeQueries(true)
+	var tag string
+	db.SetOnQuery(func(query string, s Source, d time.Duration, err error) {
+		tag = query
+	})
+
+	p.setErr(errTest)
+	queryValue(t, db, "select * from users where id = 42")
+	if !strings.Contains(buf.String(), `sql:"select * from users where id = ?"`) {
+		t.Fatalf("got log %q", buf.String())
+	}
+	if tag != "select * from users where id = ?" {
+		t.Fatalf("got tag %q", tag)
+	}
+}
@@ -0,0 +1,101 @@
+package retrydb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxRecentPrimary bounds the number of queries tracked for
+// SetNoRowsFallback
+const maxRecentPrimary = 1024
+
+// recentQueries tracks when queries, with their arguments, last succeeded on
+// the Primary
+type recentQueries struct {
+	sync.Mutex
+	m map[string]time.Time
+}
+
+func newRecentQueries() *recentQueries {
+	return &recentQueries{m: make(map[string]time.Time)}
+}
+
+func recentKey(query string, args []interface{}) string {
+	return fmt.Sprintf("%s\x00%#v", query, args)
+}
+
+// SetNoRowsFallback re-runs a QueryRow against the Primary when the Secondary
+// returns no rows for a query (by SQL text and arguments) that succeeded on
+// the Primary within recent, since a lagging replica may not have the row
+// yet. Only QueryRow and QueryRowServedBy fall back. Zero (the default)
+// disables it.
+func (db *RetryDB) SetNoRowsFallback(recent time.Duration) {
+	db.Lock()
+	db.noRowsRecent = recent
+	if recent > 0 && db.recentPrimary == nil {
+		db.recentPrimary = newRecentQueries()
+	}
+	db.Unlock()
+}
+
+// recordPrimarySuccess notes that query succeeded on the Primary
+func (db *RetryDB) recordPrimarySuccess(query string, args []interface{}) {
+	db.RLock()
+	recent := db.recentPrimary
+	enabled := db.noRowsRecent > 0
+	db.RUnlock()
+	if !enabled {
+		return
+	}
+	key := recentKey(query, args)
+	recent.Lock()
+	if len(recent.m) >= maxRecentPrimary {
+		recent.m = make(map[string]time.Time)
+	}
+	recent.m[key] = time.Now()
+	recent.Unlock()
+}
+
+// recentlyOnPrimary returns true if query succeeded on the Primary recently
+func (db *RetryDB) recentlyOnPrimary(query string, args []interface{}) bool {
+	db.RLock()
+	recent, within := db.recentPrimary, db.noRowsRecent
+	db.RUnlock()
+	if within <= 0 {
+		return false
+	}
+	recent.Lock()
+	t, ok := recent.m[recentKey(query, args)]
+	recent.Unlock()
+	return ok && time.Since(t) < within
+}
+
+// queryRow runs query for QueryRow, falling back to the Primary when the
+// Secondary has no rows (see SetNoRowsFallback)
+func (db *RetryDB) queryRow(ctx context.Context, query string, args ...interface{}) (*Row, Source) {
+	rows, info, err := db.query(ctx, query, args...)
+	row := &Row{rows: rows, err: err}
+	if err != nil || info.Source != SourceSecondary || !db.recentlyOnPrimary(query, args) {
+		return row, info.Source
+	}
+	if rows.Next() {
+		row.advanced = true
+		return row, info.Source
+	}
+	if rows.Err() != nil {
+		return row, info.Source
+	}
+	rows.Close()
+	rows, err = db.primaryQueryRetry(ctx, query, args...)
+	return &Row{rows: rows, err: err}, SourcePrimary
+}
+
+// first advances to the first row
+func (r *Row) first() bool {
+	if r.advanced {
+		return true
+	}
+	return r.rows.Next()
+}
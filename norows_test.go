@@ -0,0 +1,55 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestNoRowsFallback(t *testing.T) {
+	db, p, s := newTestDB(t)
+	s.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"source"}, nil, nil
+	})
+	const query = "select name from users where id = ?"
+	scan := func(row *Row) (string, error) {
+		var v string
+		err := row.Scan(&v)
+		return v, err
+	}
+
+	queryValue(t, db, query, 1)
+	db.updateRetry(context.Background(), ReasonSlowQuery, errTest)
+
+	// disabled
+	if _, err := scan(db.QueryRow(query, 1)); err != sql.ErrNoRows {
+		t.Fatalf("got %v expected %v", err, sql.ErrNoRows)
+	}
+
+	db.SetNoRowsFallback(time.Minute)
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	queryValue(t, db, query, 1)
+	db.updateRetry(context.Background(), ReasonSlowQuery, errTest)
+
+	row, source := db.QueryRowServedBy(query, 1)
+	if v, err := scan(row); err != nil || v != p.name || source != SourcePrimary {
+		t.Fatalf("got %q %s %v expected %q from primary", v, source, err, p.name)
+	}
+	// queries not recently run on the primary don't fall back, including with
+	// other arguments
+	if _, err := scan(db.QueryRow("select 1")); err != sql.ErrNoRows {
+		t.Fatalf("got %v expected %v", err, sql.ErrNoRows)
+	}
+	if _, err := scan(db.QueryRow(query, 2)); err != sql.ErrNoRows {
+		t.Fatalf("got %v expected %v", err, sql.ErrNoRows)
+	}
+
+	// a secondary row is returned as is
+	s.setHandler(nil)
+	row, source = db.QueryRowServedBy(query, 3)
+	if v, err := scan(row); err != nil || v != s.name || source != SourceSecondary {
+		t.Fatalf("got %q %s %v expected %q from secondary", v, source, err, s.name)
+	}
+}
@@ -0,0 +1,51 @@
+package retrydb
+
+import (
+	"context"
+	"time"
+)
+
+// SetOnExtendedOutage sets a hook called once when the Primary has been
+// continuously disabled (from the first failure until it is re-enabled) for
+// longer than d, for example to start promoting the Secondary. It fires at
+// most once per outage, on its own goroutine.
+func (db *RetryDB) SetOnExtendedOutage(d time.Duration, f func()) {
+	db.Lock()
+	db.outageAfter = d
+	db.onOutage = f
+	db.Unlock()
+}
+
+// startOutageTimer arms the extended outage hook; db must be locked
+func (db *RetryDB) startOutageTimer() {
+	if db.onOutage == nil {
+		return
+	}
+	if db.stopOutage != nil {
+		db.stopOutage()
+	}
+	db.outageSeq++
+	seq, f := db.outageSeq, db.onOutage
+	db.stopOutage = db.afterFunc(db.outageAfter, func() {
+		db.RLock()
+		fire := db.outageSeq == seq && db.primaryDisabled()
+		db.RUnlock()
+		if fire {
+			db.callback(context.Background(), "OnExtendedOutage", f)
+		}
+	})
+}
+
+// stopOutageTimer disarms the extended outage hook; db must be locked
+func (db *RetryDB) stopOutageTimer() {
+	if db.stopOutage != nil {
+		db.stopOutage()
+		db.stopOutage = nil
+	}
+	db.outageSeq++
+}
+
+// timeAfterFunc is time.AfterFunc returning the func stopping the timer
+func timeAfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
@@ -0,0 +1,64 @@
+package retrydb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeTimer is a timer from fakeAfterFunc, fired by calling f
+type fakeTimer struct {
+	d       time.Duration
+	f       func()
+	stopped bool
+}
+
+// fakeAfterFunc replaces time.AfterFunc for db, appending each timer to
+// timers instead of starting it
+func fakeAfterFunc(db *RetryDB, timers *[]*fakeTimer) {
+	db.Lock()
+	db.afterFunc = func(d time.Duration, f func()) func() bool {
+		t := &fakeTimer{d: d, f: f}
+		*timers = append(*timers, t)
+		return func() bool {
+			active := !t.stopped
+			t.stopped = true
+			return active
+		}
+	}
+	db.Unlock()
+}
+
+func TestOnExtendedOutage(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	var timers []*fakeTimer
+	fakeAfterFunc(db, &timers)
+	var calls int
+	db.SetOnExtendedOutage(30*time.Millisecond, func() { calls++ })
+
+	// a short outage does not fire, even if the timer fires as it is stopped
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	if len(timers) != 1 || timers[0].d != 30*time.Millisecond {
+		t.Fatalf("got timers %v expected one for 30ms", timers)
+	}
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	if !timers[0].stopped {
+		t.Fatal("expected the timer to be stopped when the primary is re-enabled")
+	}
+	timers[0].f()
+	if calls != 0 {
+		t.Fatalf("got %d calls for a short outage", calls)
+	}
+
+	// a sustained outage with repeated failures arms one timer, which fires
+	queryValue(t, db, "select 1")
+	db.updateRetry(context.Background(), ReasonQueryError, errTest)
+	if len(timers) != 2 || timers[1].stopped {
+		t.Fatalf("got timers %v expected a second running timer", timers)
+	}
+	timers[1].f()
+	if calls != 1 {
+		t.Fatalf("got %d calls expected 1", calls)
+	}
+}
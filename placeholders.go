@@ -0,0 +1,132 @@
+package retrydb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrArgCountMismatch is returned when SetPlaceholderValidation is enabled and
+// the number of args does not match the placeholders in a query
+var ErrArgCountMismatch = errors.New("retrydb: arg count does not match placeholders")
+
+// PlaceholderStyle selects how placeholders are counted by SetPlaceholderValidation
+type PlaceholderStyle int
+
+const (
+	NoPlaceholderValidation PlaceholderStyle = iota // default
+	QuestionPlaceholders                            // MySQL style ?
+	DollarPlaceholders                              // Postgres style $1, $2
+)
+
+// SetPlaceholderValidation checks the number of args passed to Query and Exec
+// against the placeholders in the query before running it, returning
+// ErrArgCountMismatch on a mismatch. Placeholders within comments and quoted
+// strings (including Postgres $$ bodies) are ignored but this is a lightweight
+// scan, not a SQL parser, which is why it is opt-in. Queries with sql.NamedArg
+// args are not validated.
+func (db *RetryDB) SetPlaceholderValidation(style PlaceholderStyle) {
+	db.Lock()
+	db.placeholderStyle = style
+	db.Unlock()
+}
+
+func (db *RetryDB) validateArgs(query string, args []interface{}) error {
+	db.RLock()
+	style := db.placeholderStyle
+	db.RUnlock()
+	if style == NoPlaceholderValidation {
+		return nil
+	}
+	for _, arg := range args {
+		if _, ok := arg.(sql.NamedArg); ok {
+			return nil
+		}
+	}
+	if n := countPlaceholders(query, style); n != len(args) {
+		return fmt.Errorf("%w: %d placeholders, %d args", ErrArgCountMismatch, n, len(args))
+	}
+	return nil
+}
+
+// countPlaceholders returns the number of ? placeholders, or the highest $n
+// placeholder, outside of comments, quoted strings and (for
+// DollarPlaceholders) dollar quoted bodies
+func countPlaceholders(query string, style PlaceholderStyle) int {
+	var count int
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end == -1 {
+				return count
+			}
+			i += end + 3
+		case c == '\'' || c == '"' || c == '`':
+			// a doubled quote closes and reopens the string. Backslash escapes
+			// are MySQL's; Postgres only has them in E'...' strings.
+			escapes := c != '`' && style == QuestionPlaceholders ||
+				c == '\'' && i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && !identByte(query, i-2)
+			for i++; i < len(query) && query[i] != c; i++ {
+				if query[i] == '\\' && escapes {
+					i++
+				}
+			}
+		case c == '?' && style == QuestionPlaceholders:
+			count++
+		case c == '$' && style == DollarPlaceholders:
+			if tag := dollarTag(query, i); tag != "" {
+				end := strings.Index(query[i+len(tag):], tag)
+				if end == -1 {
+					return count
+				}
+				i += len(tag) + end + len(tag) - 1
+				continue
+			}
+			var n int
+			for i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9' {
+				i++
+				n = n*10 + int(query[i]-'0')
+			}
+			if n > count {
+				count = n
+			}
+		}
+	}
+	return count
+}
+
+// dollarTag returns the opening tag, such as $$ or $body$, of a Postgres
+// dollar quoted string starting at query[i], or "" if there is none
+func dollarTag(query string, i int) string {
+	if identByte(query, i-1) {
+		return ""
+	}
+	for j := i + 1; j < len(query); j++ {
+		c := query[j]
+		switch {
+		case c == '$':
+			return query[i : j+1]
+		case c >= '0' && c <= '9' && j == i+1:
+			return ""
+		case !identByte(query, j):
+			return ""
+		}
+	}
+	return ""
+}
+
+// identByte returns true if query[i] can be part of an identifier
+func identByte(query string, i int) bool {
+	if i < 0 || i >= len(query) {
+		return false
+	}
+	c := query[i]
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
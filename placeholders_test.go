@@ -0,0 +1,56 @@
+package retrydb
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestCountPlaceholders(t *testing.T) {
+	tests := []struct {
+		query    string
+		style    PlaceholderStyle
+		expected int
+	}{
+		{"select * from t where a = ? and b = ?", QuestionPlaceholders, 2},
+		{"select * from t where a = '?' and b = ?", QuestionPlaceholders, 1},
+		{"select * from t where a = $1 and b = $2 or c = $1", DollarPlaceholders, 2},
+		{"select * from t where a = '$3' and b = $12", DollarPlaceholders, 12},
+		{"select 1", DollarPlaceholders, 0},
+		{"select ? -- why?\nfrom t /* a = ? */ where b = ?", QuestionPlaceholders, 2},
+		{"select ? /* unterminated ?", QuestionPlaceholders, 1},
+		{`select 'it\'s ?' , 'it''s ?', "?", ?`, QuestionPlaceholders, 1},
+		{"select `a?` from t where b = ?", QuestionPlaceholders, 1},
+		{"select $1 -- $2\n/* $3 */", DollarPlaceholders, 1},
+		{"select $$ $2 $$, $body$ $3 $$ $body$, $1", DollarPlaceholders, 1},
+		{"select $1 where a = $$ $2", DollarPlaceholders, 1},
+		{"select a$b, $2", DollarPlaceholders, 2},
+		{`select 1 where path = 'C:\' and id = $1`, DollarPlaceholders, 1},
+		{`select E'it\'s $2', e'\\', $1`, DollarPlaceholders, 1},
+		{`select 1 where name = 'E' and path = 'C:\' and id = $1`, DollarPlaceholders, 1},
+	}
+	for _, tc := range tests {
+		if n := countPlaceholders(tc.query, tc.style); n != tc.expected {
+			t.Errorf("%q got %d expected %d", tc.query, n, tc.expected)
+		}
+	}
+}
+
+func TestPlaceholderValidation(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	// validation is off by default
+	queryValue(t, db, "select ?", 1, 2)
+
+	db.SetPlaceholderValidation(QuestionPlaceholders)
+	queryValue(t, db, "select ? from t where b = ?", 1, 2)
+	if _, err := db.Query("select ?", 1, 2); !errors.Is(err, ErrArgCountMismatch) {
+		t.Fatalf("got %v expected %v", err, ErrArgCountMismatch)
+	}
+	if _, err := db.Exec("insert into t values (?, ?)", 1); !errors.Is(err, ErrArgCountMismatch) {
+		t.Fatalf("got %v expected %v", err, ErrArgCountMismatch)
+	}
+	queryValue(t, db, "select :a", sql.Named("a", 1))
+	if n := p.queryCount(); n != 3 || p.execCount() != 0 {
+		t.Fatalf("got %d queries %d execs", n, p.execCount())
+	}
+}
@@ -0,0 +1,53 @@
+package retrydb
+
+import (
+	"context"
+)
+
+// PoolSaturation returns the fraction of the maximum open connections in use
+// for the Primary and Secondary. It is 0 for an endpoint without a connection
+// limit (see SetMaxOpenConns) or without pool stats.
+func (db *RetryDB) PoolSaturation() (primary, secondary float64) {
+	return saturation(db.Primary), saturation(db.Secondary)
+}
+
+func saturation(endpoint Retryable) float64 {
+	s := endpointStats(endpoint, nil)
+	if s == nil || s.MaxOpenConnections <= 0 {
+		return 0
+	}
+	return float64(s.InUse) / float64(s.MaxOpenConnections)
+}
+
+// SetPoolSaturationWarn calls f after a query when the pool saturation (see
+// PoolSaturation) of the endpoint that served it is at least threshold, as an
+// early warning of slowdowns. A nil f disables the check.
+func (db *RetryDB) SetPoolSaturationWarn(threshold float64, f func(source Source, saturation float64)) {
+	db.Lock()
+	db.saturationWarn = threshold
+	db.onSaturation = f
+	db.Unlock()
+}
+
+// checkPoolSaturation calls the SetPoolSaturationWarn callback when the pool
+// of source is saturated
+func (db *RetryDB) checkPoolSaturation(ctx context.Context, source Source) {
+	db.RLock()
+	threshold, f := db.saturationWarn, db.onSaturation
+	db.RUnlock()
+	if f == nil {
+		return
+	}
+	var sat float64
+	switch source {
+	case SourcePrimary:
+		sat = saturation(db.Primary)
+	case SourceSecondary:
+		sat = saturation(db.Secondary)
+	default:
+		return
+	}
+	if sat > 0 && sat >= threshold {
+		db.callback(ctx, "OnPoolSaturation", func() { f(source, sat) })
+	}
+}
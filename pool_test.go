@@ -0,0 +1,42 @@
+package retrydb
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestPoolSaturation(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	if p, s := db.PoolSaturation(); p != 0 || s != 0 {
+		t.Fatalf("got saturation %v %v for unlimited pools", p, s)
+	}
+	db.SetMaxOpenConns(2)
+	var warnings []float64
+	db.SetPoolSaturationWarn(0.9, func(source Source, saturation float64) {
+		if source != SourcePrimary {
+			t.Errorf("got warning for %s", source)
+		}
+		warnings = append(warnings, saturation)
+	})
+
+	var open []*sql.Rows
+	for i := 0; i < 2; i++ {
+		rows, err := db.Query("select 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		open = append(open, rows)
+	}
+	if len(warnings) != 1 || warnings[0] != 1 {
+		t.Fatalf("got warnings %v", warnings)
+	}
+	if p, s := db.PoolSaturation(); p != 1 || s != 0 {
+		t.Fatalf("got saturation %v %v", p, s)
+	}
+	for _, rows := range open {
+		rows.Close()
+	}
+	if p, _ := db.PoolSaturation(); p != 0 {
+		t.Fatalf("got saturation %v after closing rows", p)
+	}
+}
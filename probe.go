@@ -0,0 +1,105 @@
+package retrydb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SetAsyncProbe probes the Primary in the background once the failover window
+// expires instead of with the next query. Queries continue to be served by the
+// Secondary until a ping of the Primary succeeds, or the window is re-opened
+// if the ping fails, so no query waits on a Primary that may still be slow.
+func (db *RetryDB) SetAsyncProbe(async bool) {
+	db.Lock()
+	db.asyncProbe = async
+	db.Unlock()
+}
+
+// probeAsync returns true if the query should stay on the Secondary while
+// the Primary is probed in the background
+func (db *RetryDB) probeAsync(start time.Time) bool {
+	db.RLock()
+	async := db.asyncProbe
+	db.RUnlock()
+	if !async || !db.primaryDisabled() || db.secondaryOpen(start) {
+		return false
+	}
+	if db.probing.CompareAndSwap(false, true) {
+		go db.runProbe()
+	}
+	return true
+}
+
+// runProbe pings the Primary, re-enabling it on success
+func (db *RetryDB) runProbe() {
+	defer db.probing.Store(false)
+	db.RLock()
+	timeout := db.maxQueryTime
+	db.RUnlock()
+	ctx, cancel := context.WithTimeout(db.ctx, timeout)
+	defer cancel()
+	err := pingContext(ctx, db.Primary)
+	if isContextError(err) {
+		return
+	}
+	db.probed(true, err)
+	if err != nil {
+		db.updateRetry(ctx, ReasonQueryError, fmt.Errorf("async probe errored with %s", err))
+		return
+	}
+	db.primaryRecovered(ctx)
+}
+
+// SetProbeInterval pings the Primary every d in the background while it is
+// disabled, independent of the RetryStrategy window, reporting each result to
+// the SetOnProbe callback. Queries stay on the Secondary until the failover
+// window expires. A d of 0 disables background probes.
+func (db *RetryDB) SetProbeInterval(d time.Duration) {
+	db.Lock()
+	db.primaryProbeInterval = d
+	db.Unlock()
+}
+
+// startProbeLoop starts background probes of a disabled Primary; db must be
+// locked
+func (db *RetryDB) startProbeLoop() {
+	if db.primaryProbeInterval <= 0 || db.probeStop != nil {
+		return
+	}
+	db.probeStop = make(chan struct{})
+	go db.probeLoop(db.primaryProbeInterval, db.probeStop)
+}
+
+// stopProbeLoop stops background probes; db must be locked
+func (db *RetryDB) stopProbeLoop() {
+	if db.probeStop != nil {
+		close(db.probeStop)
+		db.probeStop = nil
+	}
+}
+
+// probeLoop pings the Primary every interval until stop is closed or the
+// RetryDB is shut down
+func (db *RetryDB) probeLoop(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-db.ctx.Done():
+			return
+		case <-t.C:
+		}
+		db.RLock()
+		timeout := db.maxQueryTime
+		db.RUnlock()
+		ctx, cancel := context.WithTimeout(db.ctx, timeout)
+		err := pingContext(ctx, db.Primary)
+		cancel()
+		if !isContextError(err) {
+			db.probed(true, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package retrydb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAsyncProbe(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetRetryStrategy(func(uint32) time.Duration { return 10 * time.Millisecond })
+	db.SetAsyncProbe(true)
+	probes := make(chan bool, 2)
+	db.SetOnProbe(func(success bool, err error) { probes <- success })
+
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	time.Sleep(10 * time.Millisecond)
+
+	// the window expired; the query stays on the secondary while the primary
+	// ping fails in the background
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if success := <-probes; success {
+		t.Fatal("expected a failed probe")
+	}
+	// wait for the re-opened window to expire
+	for db.probing.Load() || db.retrying(time.Now()) {
+		time.Sleep(time.Millisecond)
+	}
+
+	p.setErr(nil)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if success := <-probes; !success {
+		t.Fatal("expected a successful probe")
+	}
+	for db.Stats().RetryCount != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if n := p.queryCount(); n != 2 {
+		t.Fatalf("got %d primary queries expected 2", n)
+	}
+}
+
+func TestProbeInterval(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetRetryStrategy(func(uint32) time.Duration { return time.Minute })
+	db.SetProbeInterval(5 * time.Millisecond)
+	probes := make(chan bool, 100)
+	db.SetOnProbe(func(success bool, err error) { probes <- success })
+
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	for i := 0; i < 3; i++ {
+		if success := <-probes; success {
+			t.Fatal("expected a failed probe")
+		}
+	}
+
+	// successful probes do not end the failover window
+	p.setErr(nil)
+	for success := false; !success; {
+		success = <-probes
+	}
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := p.queryCount(); n != 1 {
+		t.Fatalf("got %d primary queries expected 1", n)
+	}
+
+	// re-enabling the primary stops the probes
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	time.Sleep(10 * time.Millisecond)
+	for len(probes) > 0 {
+		<-probes
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := len(probes); n != 0 {
+		t.Fatalf("got %d probes after re-enabling", n)
+	}
+}
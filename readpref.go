@@ -0,0 +1,64 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ReadPreference controls which endpoint Query routes reads to by default
+type ReadPreference int
+
+const (
+	// PrimaryPreferred queries the Primary, failing over to the Secondary on
+	// error or while the Primary is disabled. This is the default.
+	PrimaryPreferred ReadPreference = iota
+	// SecondaryPreferred queries the Secondary, falling back to the Primary
+	// if the Secondary errors or its circuit is open (see SetFailFast)
+	SecondaryPreferred
+	// PrimaryOnly queries the Primary and returns its errors without failover
+	PrimaryOnly
+	// SecondaryOnly queries the Secondary and returns its errors
+	SecondaryOnly
+)
+
+func (p ReadPreference) String() string {
+	switch p {
+	case SecondaryPreferred:
+		return "secondary_preferred"
+	case PrimaryOnly:
+		return "primary_only"
+	case SecondaryOnly:
+		return "secondary_only"
+	}
+	return "primary_preferred"
+}
+
+// SetReadPreference sets the default routing for queries. Only
+// PrimaryPreferred uses the failover window; the other preferences neither
+// open it nor consult it, and PinSecondary has no effect on them. Queries
+// using ForcePrimary always go to the Primary, and without a Secondary all
+// queries go to the Primary.
+func (db *RetryDB) SetReadPreference(p ReadPreference) {
+	db.Lock()
+	db.readPreference = p
+	db.Unlock()
+}
+
+// secondaryPreferredQuery queries the Secondary falling back to the Primary
+func (db *RetryDB) secondaryPreferredQuery(ctx context.Context, query string, args ...interface{}) (*sql.Rows, QueryInfo, error) {
+	info := QueryInfo{Source: SourcePrimary}
+	if !db.secondaryOpen(time.Now()) {
+		rows, source, err := db.secondaryQueryRetry(ctx, query, args...)
+		ferr := db.fatalError(source, err, rows)
+		if ferr == nil || callerContextError(ctx, ferr) || source != SourceSecondary {
+			return rows, QueryInfo{Source: source}, err
+		}
+		if rows != nil {
+			rows.Close()
+		}
+		info.Failover = true
+	}
+	rows, err := db.primaryQueryRetry(ctx, query, args...)
+	return rows, info, err
+}
@@ -0,0 +1,49 @@
+package retrydb
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReadPreference(t *testing.T) {
+	for _, tc := range []struct {
+		pref             ReadPreference
+		ok, primaryDown  Source
+		secondaryDown    Source
+		primaryDownErr   bool
+		secondaryDownErr bool
+	}{
+		{pref: PrimaryPreferred, ok: SourcePrimary, primaryDown: SourceSecondary, secondaryDown: SourcePrimary},
+		{pref: SecondaryPreferred, ok: SourceSecondary, primaryDown: SourceSecondary, secondaryDown: SourcePrimary},
+		{pref: PrimaryOnly, ok: SourcePrimary, primaryDown: SourcePrimary, secondaryDown: SourcePrimary, primaryDownErr: true},
+		{pref: SecondaryOnly, ok: SourceSecondary, primaryDown: SourceSecondary, secondaryDown: SourceSecondary, secondaryDownErr: true},
+	} {
+		t.Run(tc.pref.String(), func(t *testing.T) {
+			db, p, s := newTestDB(t)
+			db.SetReadPreference(tc.pref)
+			check := func(name string, want Source, wantErr bool) {
+				t.Helper()
+				rows, source, err := db.QueryServedBy("select 1")
+				if wantErr {
+					if err != errTest {
+						t.Fatalf("%s: got %v expected %v", name, err, errTest)
+					}
+				} else {
+					scanValue(t, rows, err)
+				}
+				if source != want {
+					t.Fatalf("%s: got %s expected %s", name, source, want)
+				}
+			}
+			check("healthy", tc.ok, false)
+
+			p.setErr(errTest)
+			check("primary down", tc.primaryDown, tc.primaryDownErr)
+			p.setErr(nil)
+			db.updateRetry(context.Background(), ReasonNone, nil)
+
+			s.setErr(errTest)
+			check("secondary down", tc.secondaryDown, tc.secondaryDownErr)
+		})
+	}
+}
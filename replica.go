@@ -0,0 +1,167 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrReplicaLag is returned instead of querying a Secondary that is lagging
+// more than SetMaxReplicaLag allows
+var ErrReplicaLag = errors.New("retrydb: replica lag exceeds limit")
+
+// ReplicaWaitFunc blocks until the replica behind conn has caught up to the
+// consistency token in ctx (see ConsistencyToken). For MySQL this could run
+// SELECT WAIT_FOR_EXECUTED_GTID_SET(?, timeout).
+type ReplicaWaitFunc func(ctx context.Context, conn *sql.Conn) error
+
+// WithConsistencyToken returns a context carrying a consistency token, such
+// as the last GTID set seen by the caller, for use by a ReplicaWaitFunc
+func WithConsistencyToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, consistencyTokenKey, token)
+}
+
+// ConsistencyToken returns the token set with WithConsistencyToken
+func ConsistencyToken(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(consistencyTokenKey).(string)
+	return token, ok
+}
+
+// SetReplicaWaitFunc sets a hook called before a Secondary read when the
+// query context carries a consistency token. The hook runs on a dedicated
+// Secondary connection which is returned to the pool before the query runs.
+// If the hook errors the query fails with that error.
+func (db *RetryDB) SetReplicaWaitFunc(f ReplicaWaitFunc) {
+	db.Lock()
+	db.replicaWait = f
+	db.Unlock()
+}
+
+func (db *RetryDB) waitForReplica(ctx context.Context, secondary Retryable) error {
+	if _, ok := ConsistencyToken(ctx); !ok {
+		return nil
+	}
+	db.RLock()
+	wait := db.replicaWait
+	db.RUnlock()
+	if wait == nil {
+		return nil
+	}
+	c, ok := secondary.(conner)
+	if !ok {
+		return nil
+	}
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = errCallbackPanic
+	db.callback(ctx, "replica wait", func() { err = wait(ctx, conn) })
+	if err != nil {
+		return fmt.Errorf("retrydb: waiting for replica: %w", err)
+	}
+	return nil
+}
+
+// SetMaxReplicaLag checks the Secondary replication lag with lagFunc before
+// every Secondary read and fails the read with ErrReplicaLag when it exceeds
+// max (or SetReplicaLagFallback sends it to the Primary). lagFunc is called
+// for every Secondary read so it should cache its result. A max of zero
+// disables the check.
+func (db *RetryDB) SetMaxReplicaLag(max time.Duration, lagFunc func(context.Context, Retryable) (time.Duration, error)) {
+	db.Lock()
+	db.maxReplicaLag = max
+	db.replicaLag = lagFunc
+	db.Unlock()
+}
+
+// SetReplicaLagFallback queries the Primary instead of returning an error
+// when the Secondary is too far behind (see SetMaxReplicaLag). While the
+// Primary is disabled the error is still returned, and a failed fallback
+// query opens the failover window like any other Primary query.
+func (db *RetryDB) SetReplicaLagFallback(tryPrimary bool) {
+	db.Lock()
+	db.replicaLagFallback = tryPrimary
+	db.Unlock()
+}
+
+// checkReplicaLag returns an error if secondary lags more than the max replica
+// lag
+func (db *RetryDB) checkReplicaLag(ctx context.Context, secondary Retryable) error {
+	db.RLock()
+	max, lagFunc := db.maxReplicaLag, db.replicaLag
+	db.RUnlock()
+	if max <= 0 || lagFunc == nil {
+		return nil
+	}
+	lag, err := db.replicaLagOf(ctx, lagFunc, secondary)
+	if err != nil {
+		return fmt.Errorf("retrydb: checking replica lag: %w", err)
+	}
+	if lag > max {
+		return fmt.Errorf("%w (%s > %s)", ErrReplicaLag, lag, max)
+	}
+	return nil
+}
+
+// replicaLagOf runs lagFunc for secondary, failing if it panics
+func (db *RetryDB) replicaLagOf(ctx context.Context, lagFunc func(context.Context, Retryable) (time.Duration, error), secondary Retryable) (lag time.Duration, err error) {
+	err = errCallbackPanic
+	db.callback(ctx, "replica lag", func() { lag, err = lagFunc(ctx, secondary) })
+	return lag, err
+}
+
+// SetReplicaTimestampFunc sets the function reporting the time of the last
+// change applied by the Secondary, used by WithMaxStaleness
+func (db *RetryDB) SetReplicaTimestampFunc(f func(context.Context, Retryable) (time.Time, error)) {
+	db.Lock()
+	db.replicaTimestamp = f
+	db.Unlock()
+}
+
+// WithMaxStaleness returns a context that only allows the query to be served
+// by the Secondary when its last applied change (see SetReplicaTimestampFunc)
+// is at most d old. Otherwise, including when the timestamp is unknown, the
+// query is sent to the Primary, or fails with ErrReplicaLag while the Primary
+// is disabled.
+func WithMaxStaleness(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, maxStalenessKey, d)
+}
+
+// primaryFallback runs a read the Secondary can't serve because of cause
+// against the Primary, unless the Primary is disabled, recording a failure
+// like any other Primary query
+func (db *RetryDB) primaryFallback(ctx context.Context, cause error, query string, args []interface{}) (*sql.Rows, Source, error) {
+	start := time.Now()
+	if db.retrying(start) {
+		return nil, SourceNone, cause
+	}
+	rows, err := db.primaryQueryRetry(ctx, query, args...)
+	ferr, stands := db.primaryError(ctx, err, rows, query, time.Since(start))
+	if !stands && db.recordPrimaryOutcome(ferr != nil) {
+		db.updateRetry(ctx, ReasonQueryError, fmt.Errorf("query errored with %s. secondary unusable (%s). sql:%s", ferr, cause, db.formatQuery(query, args)))
+	}
+	return rows, SourcePrimary, err
+}
+
+// tooStale reports whether secondary is staler than WithMaxStaleness allows
+// for ctx
+func (db *RetryDB) tooStale(ctx context.Context, secondary Retryable) bool {
+	max, ok := ctx.Value(maxStalenessKey).(time.Duration)
+	if !ok {
+		return false
+	}
+	db.RLock()
+	f := db.replicaTimestamp
+	db.RUnlock()
+	if f == nil {
+		return true
+	}
+	var ts time.Time
+	err := errCallbackPanic
+	db.callback(ctx, "replica timestamp", func() { ts, err = f(ctx, secondary) })
+	return err != nil || time.Since(ts) > max
+}
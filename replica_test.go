@@ -0,0 +1,136 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReplicaWaitFunc(t *testing.T) {
+	db, p, s := newTestDB(t)
+	var tokens []string
+	db.SetReplicaWaitFunc(func(ctx context.Context, conn *sql.Conn) error {
+		token, _ := ConsistencyToken(ctx)
+		tokens = append(tokens, token)
+		return nil
+	})
+	ctx := WithConsistencyToken(context.Background(), "gtid:1-5")
+
+	// primary reads don't wait
+	rows, err := db.QueryContext(ctx, "select 1")
+	if v := scanValue(t, rows, err); v != p.name || len(tokens) != 0 {
+		t.Fatalf("got %q with %d waits", v, len(tokens))
+	}
+
+	p.setErr(errTest)
+	rows, err = db.QueryContext(ctx, "select 1")
+	if v := scanValue(t, rows, err); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	// secondary reads without a token don't wait
+	queryValue(t, db, "select 1")
+	if len(tokens) != 1 || tokens[0] != "gtid:1-5" {
+		t.Fatalf("got waits %q", tokens)
+	}
+
+	db.SetReplicaWaitFunc(func(ctx context.Context, conn *sql.Conn) error { return errTest })
+	if _, err := db.QueryContext(ctx, "select 1"); err == nil {
+		t.Fatal("expected wait error")
+	}
+}
+
+func TestMaxReplicaLag(t *testing.T) {
+	db, p, s := newTestDB(t)
+	lag := 5 * time.Second
+	db.SetMaxReplicaLag(10*time.Second, func(ctx context.Context, r Retryable) (time.Duration, error) {
+		if r != db.Secondary {
+			t.Fatal("expected the secondary")
+		}
+		return lag, nil
+	})
+
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+
+	lag = time.Minute
+	if _, err := db.Query("select 1"); !errors.Is(err, ErrReplicaLag) {
+		t.Fatalf("got %v expected %v", err, ErrReplicaLag)
+	}
+	if n := s.queryCount(); n != 1 {
+		t.Fatalf("got %d secondary queries expected 1", n)
+	}
+
+	// the fallback never queries a disabled primary
+	db.SetReplicaLagFallback(true)
+	if _, err := db.Query("select 1"); !errors.Is(err, ErrReplicaLag) {
+		t.Fatalf("got %v expected %v", err, ErrReplicaLag)
+	}
+	if n := p.queryCount(); n != 1 {
+		t.Fatalf("got %d primary queries expected 1", n)
+	}
+
+	// otherwise the read is served by, and reported as, the primary
+	p.setErr(nil)
+	db.ResetCircuit(SourcePrimary)
+	db.SetReadPreference(SecondaryOnly)
+	rows, info, err := db.QueryWithInfo(context.Background(), "select 1")
+	if v := scanValue(t, rows, err); v != p.name || info.Source != SourcePrimary || info.Stale {
+		t.Fatalf("got %q %+v expected %q from the primary", v, info, p.name)
+	}
+
+	// and a failed fallback opens the failover window
+	p.setErr(errTest)
+	if _, err := db.Query("select 1"); !errors.Is(err, errTest) {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if n := db.Stats().RetryCount; n != 1 {
+		t.Fatalf("got retry count %d expected 1", n)
+	}
+}
+
+func TestMaxStaleness(t *testing.T) {
+	db, p, s := newTestDB(t)
+	applied := time.Now().Add(-5 * time.Second)
+	db.SetReplicaTimestampFunc(func(ctx context.Context, r Retryable) (time.Time, error) {
+		return applied, nil
+	})
+	db.SetReadPreference(SecondaryPreferred)
+	ctx := WithMaxStaleness(context.Background(), 10*time.Second)
+
+	rows, err := db.QueryContext(ctx, "select 1")
+	if v := scanValue(t, rows, err); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+
+	applied = time.Now().Add(-time.Minute)
+	rows, info, err := db.QueryWithInfo(ctx, "select 1")
+	if v := scanValue(t, rows, err); v != p.name || info.Source != SourcePrimary || info.Stale {
+		t.Fatalf("got %q %+v expected %q from the primary", v, info, p.name)
+	}
+
+	// without a staleness bound the secondary is used
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := s.queryCount(); n != 2 {
+		t.Fatalf("got %d secondary queries expected 2", n)
+	}
+
+	// an unknown timestamp also falls back, with the source reported
+	db.SetReplicaTimestampFunc(nil)
+	db.SetReadPreference(SecondaryOnly)
+	rows, info, err = db.QueryWithInfo(ctx, "select 1")
+	if v := scanValue(t, rows, err); v != p.name || info.Source != SourcePrimary || info.Stale {
+		t.Fatalf("got %q %+v expected %q from the primary", v, info, p.name)
+	}
+
+	// and fails while the primary is disabled
+	db.DisablePrimary()
+	if _, err := db.QueryContext(ctx, "select 1"); !errors.Is(err, ErrReplicaLag) {
+		t.Fatalf("got %v expected %v", err, ErrReplicaLag)
+	}
+}
@@ -1,28 +1,152 @@
 package retrydb
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// ErrPrimaryBackoff is returned by Query when no Secondary is configured and
+// the Primary is backing off after a failure. See SetNoSecondaryBackoff.
+var ErrPrimaryBackoff = errors.New("retrydb: primary disabled after failure")
+
 // RetryDB is a wrapper around multiple *sql.DB objects providing transparent retry of queries against the secondary.
 type RetryDB struct {
-	Primary          Retryable
-	Secondary        Retryable
-	retryCount       uint32
-	retryUntil       time.Time
-	maxQueryTime     time.Duration
-	retryStrategy    RetryStrategy
-	secondaryQueries uint32
+	Primary              Retryable
+	Secondary            Retryable
+	retryCount           uint32
+	slowRetryCount       uint32
+	retryUntil           time.Time
+	maxQueryTime         time.Duration
+	retryStrategy        RetryStrategy
+	secondaryQueries     uint32
+	totalFailovers       atomic.Uint64
+	primaryErrors        atomic.Uint64
+	secondaryErrors      atomic.Uint64
+	failoverReasons      [numReasons]atomic.Uint64
+	connRetries          int
+	connRetryBackoff     time.Duration
+	dualWrite            bool
+	noSecondaryRetry     RetryStrategy
+	deadlockRetries      int
+	isDeadlock           func(error) bool
+	onQuery              func(query string, source Source, d time.Duration, err error)
+	log                  Logger
+	logContextKey        interface{}
+	recoverThreshold     int
+	recoverSuccesses     int
+	recoverQueries       int
+	slowQueryAction      SlowQueryAction
+	slowQueryFlat        bool
+	named                map[string]*namedQuery
+	errorRateThreshold   float64
+	errorRateWindow      time.Duration
+	outcomes             outcomeRing
+	pinnedUntil          time.Time
+	queryTimeout         time.Duration
+	placeholderStyle     PlaceholderStyle
+	events               chan FailoverEvent
+	eventsClosed         bool
+	droppedEvents        atomic.Uint64
+	replicaWait          ReplicaWaitFunc
+	maxOpenConns         int
+	maxIdleConns         int
+	connMaxLifetime      time.Duration
+	hedgeDelay           time.Duration
+	queryLogger          func(query string, args []interface{}) string
+	secondaryOpenFor     time.Duration
+	secondaryOpenUntil   time.Time
+	shadow               *shadowRead
+	secondaries          []Retryable
+	secondaryWeights     []int
+	secondaryWeightTotal int
+	normalizeQueries     bool
+	softQueryTime        time.Duration
+	maxReplicaLag        time.Duration
+	replicaLag           func(context.Context, Retryable) (time.Duration, error)
+	replicaTimestamp     func(context.Context, Retryable) (time.Time, error)
+	replicaLagFallback   bool
+	closed               atomic.Bool
+	name                 string
+	primaryIsFatal       func(error) bool
+	secondaryIsFatal     func(error) bool
+	readPreference       ReadPreference
+	onQueryTiming        func(query string, source Source, t QueryTiming, err error)
+	onProbe              func(success bool, err error)
+	onRetry              func(query string, attempt int, err error, source Source)
+	logThrottle          time.Duration
+	lastExtendLog        time.Time
+	suppressedLogs       int
+	asyncProbe           bool
+	probing              atomic.Bool
+	secondarySem         chan struct{}
+	secondaryWait        bool
+	hintRouting          bool
+	outageAfter          time.Duration
+	onOutage             func()
+	stopOutage           func() bool
+	afterFunc            func(time.Duration, func()) func() bool // time.AfterFunc; replaced in tests
+	outageSeq            uint64
+	primaryProbeInterval time.Duration
+	probeStop            chan struct{}
+	validationQuery      string
+	validatedSecondaries []Retryable
+	secondaryDisabled    bool
+	onExplain            func(query, plan string)
+	versionQueries       [SourceSecondary + 1]string
+	versions             serverVersions
+	failoverDecider      func(ctx context.Context, err error, query string, d time.Duration) bool
+	adaptiveMultiplier   float64
+	latencies            *latencyWindow
+	saturationWarn       float64
+	onSaturation         func(Source, float64)
+	noRowsRecent         time.Duration
+	recentPrimary        *recentQueries
+	retryJitter          float64
+	rand                 *rand.Rand
+	stmts                *stmtCache
+	primaryLatency       latencyHistogram
+	secondaryLatency     latencyHistogram
+	ctx                  context.Context // base context for QueryContext; cancelled by Shutdown
+	cancel               context.CancelFunc
 	sync.RWMutex
 }
 
+// Source identifies the endpoint that served a query
+type Source int
+
+const (
+	SourceNone Source = iota // no endpoint was queried
+	SourcePrimary
+	SourceSecondary
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourcePrimary:
+		return "primary"
+	case SourceSecondary:
+		return "secondary"
+	}
+	return "none"
+}
+
+// SlowQueryAction controls the result of a Primary query that succeeds but
+// exceeds the max query time
+type SlowQueryAction int
+
+const (
+	ReturnResult   SlowQueryAction = iota // return the slow Primary result (default)
+	RetrySecondary                        // discard the Primary result and re-run the query on the Secondary
+)
+
 type RetryStrategy func(uint32) time.Duration
 
 func defaultRetryStrategy(retryCount uint32) time.Duration {
@@ -32,35 +156,182 @@ func defaultRetryStrategy(retryCount uint32) time.Duration {
 	return 120 * time.Second
 }
 
+// Option configures optional behavior of Open
+type Option func(*openOptions)
+
+type openOptions struct {
+	warmSecondary     int
+	pingSecondary     bool
+	secondaryRequired bool
+}
+
+// WithWarmSecondary pre-populates the Secondary connection pool with n
+// connections on Open. See WarmSecondary.
+func WithWarmSecondary(n int) Option {
+	return func(o *openOptions) { o.warmSecondary = n }
+}
+
+// WithPingSecondary pings the Secondary on Open instead of waiting for it to
+// fail on first use. If the ping fails and required is true Open returns the
+// error, otherwise a warning is logged and the RetryDB is opened without a
+// Secondary.
+func WithPingSecondary(required bool) Option {
+	return func(o *openOptions) {
+		o.pingSecondary = true
+		o.secondaryRequired = required
+	}
+}
+
 // Open connections to the Primary and Secondary Database
-func Open(primaryDriverName, primaryDataSourceName, secondaryDriverName, secondaryDataSourceName string) (*RetryDB, error) {
+func Open(primaryDriverName, primaryDataSourceName, secondaryDriverName, secondaryDataSourceName string, opts ...Option) (*RetryDB, error) {
+	var o openOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
 	p, err := sql.Open(primaryDriverName, primaryDataSourceName)
 	if err != nil {
 		return nil, err
 	}
-	db := &RetryDB{
-		Primary:       p,
-		maxQueryTime:  30 * time.Second,
-		retryStrategy: defaultRetryStrategy,
-	}
+	db := newRetryDB(p, nil)
 	if secondaryDataSourceName != "" {
 		s, err := sql.Open(secondaryDriverName, secondaryDataSourceName)
 		if err != nil {
 			p.Close()
 			return nil, err
 		}
+		if o.pingSecondary {
+			if err := s.Ping(); err != nil {
+				s.Close()
+				if o.secondaryRequired {
+					p.Close()
+					return nil, err
+				}
+				db.logger(context.Background()).Printf("secondary failed ping; continuing without secondary. %s", err)
+				return db, nil
+			}
+		}
 		db.Secondary = s
+		if o.warmSecondary > 0 {
+			if err := db.WarmSecondary(context.Background(), o.warmSecondary); err != nil {
+				db.logger(context.Background()).Printf("failed warming secondary connections. %s", err)
+			}
+		}
 	}
 	return db, nil
 }
 
 func OpenWithDB(primary *sql.DB, secondary *sql.DB) *RetryDB {
+	if secondary == nil {
+		return newRetryDB(primary, nil)
+	}
+	return newRetryDB(primary, secondary)
+}
+
+// OpenWithRetryable returns a RetryDB over any Retryable, such as a
+// ConnRetryable. secondary may be nil.
+func OpenWithRetryable(primary, secondary Retryable) *RetryDB {
+	return newRetryDB(primary, secondary)
+}
+
+func newRetryDB(primary, secondary Retryable) *RetryDB {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &RetryDB{
 		Primary:       primary,
 		Secondary:     secondary,
 		maxQueryTime:  30 * time.Second,
 		retryStrategy: defaultRetryStrategy,
+		isDeadlock:    IsDeadlock,
+		afterFunc:     timeAfterFunc,
+		log:           log.Default(),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Clone returns a RetryDB sharing the Primary and Secondary pools and
+// configuration of db, but with independent retry state and counters.
+// Closing any clone closes the shared pools, so callers must manage their
+// lifecycle together.
+func (db *RetryDB) Clone() *RetryDB {
+	c := newRetryDB(db.Primary, db.Secondary)
+	db.RLock()
+	c.maxQueryTime = db.maxQueryTime
+	c.retryStrategy = db.retryStrategy
+	c.connRetries = db.connRetries
+	c.connRetryBackoff = db.connRetryBackoff
+	c.dualWrite = db.dualWrite
+	c.noSecondaryRetry = db.noSecondaryRetry
+	c.deadlockRetries = db.deadlockRetries
+	c.isDeadlock = db.isDeadlock
+	c.onQuery = db.onQuery
+	c.log = db.log
+	c.logContextKey = db.logContextKey
+	c.recoverThreshold = db.recoverThreshold
+	c.slowQueryAction = db.slowQueryAction
+	c.slowQueryFlat = db.slowQueryFlat
+	c.errorRateThreshold = db.errorRateThreshold
+	c.errorRateWindow = db.errorRateWindow
+	c.queryTimeout = db.queryTimeout
+	c.placeholderStyle = db.placeholderStyle
+	c.replicaWait = db.replicaWait
+	c.maxOpenConns = db.maxOpenConns
+	c.maxIdleConns = db.maxIdleConns
+	c.connMaxLifetime = db.connMaxLifetime
+	c.hedgeDelay = db.hedgeDelay
+	c.queryLogger = db.queryLogger
+	c.secondaryOpenFor = db.secondaryOpenFor
+	c.shadow = db.shadow
+	c.secondaries = db.secondaries
+	c.secondaryWeights = db.secondaryWeights
+	c.secondaryWeightTotal = db.secondaryWeightTotal
+	c.normalizeQueries = db.normalizeQueries
+	c.softQueryTime = db.softQueryTime
+	c.maxReplicaLag = db.maxReplicaLag
+	c.replicaLag = db.replicaLag
+	c.replicaTimestamp = db.replicaTimestamp
+	c.replicaLagFallback = db.replicaLagFallback
+	c.name = db.name
+	c.primaryIsFatal = db.primaryIsFatal
+	c.secondaryIsFatal = db.secondaryIsFatal
+	c.readPreference = db.readPreference
+	c.onQueryTiming = db.onQueryTiming
+	c.onProbe = db.onProbe
+	c.onRetry = db.onRetry
+	c.logThrottle = db.logThrottle
+	c.asyncProbe = db.asyncProbe
+	c.secondarySem = db.secondarySem
+	c.secondaryWait = db.secondaryWait
+	c.hintRouting = db.hintRouting
+	c.outageAfter = db.outageAfter
+	c.primaryProbeInterval = db.primaryProbeInterval
+	c.validationQuery = db.validationQuery
+	c.secondaryDisabled = db.secondaryDisabled
+	c.onExplain = db.onExplain
+	c.versionQueries = db.versionQueries
+	c.failoverDecider = db.failoverDecider
+	c.adaptiveMultiplier = db.adaptiveMultiplier
+	if db.latencies != nil {
+		c.latencies = newLatencyWindow(cap(db.latencies.samples))
+	}
+	c.saturationWarn = db.saturationWarn
+	c.onSaturation = db.onSaturation
+	c.onOutage = db.onOutage
+	c.noRowsRecent = db.noRowsRecent
+	if db.recentPrimary != nil {
+		c.recentPrimary = newRecentQueries()
+	}
+	c.retryJitter = db.retryJitter
+	if db.stmts != nil {
+		c.stmts = newStmtCache(db.stmts.size)
+	}
+	for name, n := range db.named {
+		if c.named == nil {
+			c.named = make(map[string]*namedQuery, len(db.named))
+		}
+		c.named[name] = &namedQuery{query: n.query}
 	}
+	db.RUnlock()
+	return c
 }
 
 func (db *RetryDB) SetMaxQueryTime(t time.Duration) {
@@ -69,6 +340,28 @@ func (db *RetryDB) SetMaxQueryTime(t time.Duration) {
 	db.Unlock()
 }
 
+// WithMaxQueryTime sets the max query time to d while fn runs, restoring the
+// previous value when fn returns or panics. The setting is global, so queries
+// from other goroutines running at the same time also use d, and overlapping
+// calls may restore each other's values.
+func (db *RetryDB) WithMaxQueryTime(d time.Duration, fn func() error) error {
+	db.Lock()
+	previous := db.maxQueryTime
+	db.maxQueryTime = d
+	db.Unlock()
+	defer db.SetMaxQueryTime(previous)
+	return fn()
+}
+
+// SetSoftQueryTime sets a warning threshold below SetMaxQueryTime. Primary
+// queries slower than d but within the max query time are logged without
+// failing over. It is ignored unless less than the max query time.
+func (db *RetryDB) SetSoftQueryTime(d time.Duration) {
+	db.Lock()
+	db.softQueryTime = d
+	db.Unlock()
+}
+
 // Set the retry interval for which the master will be retried after a query failure. (all queries go to secondary)
 func (db *RetryDB) SetRetryStrategy(s RetryStrategy) {
 	db.Lock()
@@ -76,121 +369,864 @@ func (db *RetryDB) SetRetryStrategy(s RetryStrategy) {
 	db.Unlock()
 }
 
+// NextRetryInterval returns how long the Primary would be disabled by the next
+// failure, given the current retry count, without changing any state
+func (db *RetryDB) NextRetryInterval() time.Duration {
+	db.RLock()
+	defer db.RUnlock()
+	return db.retryInterval()
+}
+
+// retryInterval applies the retry strategy to the current retry count; db
+// must be locked
+func (db *RetryDB) retryInterval() time.Duration {
+	return db.activeRetryStrategy()(db.retryCount)
+}
+
+// activeRetryStrategy returns the retry strategy in effect; db must be locked
+func (db *RetryDB) activeRetryStrategy() RetryStrategy {
+	if (db.Secondary == nil || db.secondaryDisabled) && db.noSecondaryRetry != nil {
+		return db.noSecondaryRetry
+	}
+	return db.retryStrategy
+}
+
+// SetConnRetries retries a query up to n times against the same endpoint,
+// waiting backoff between attempts, when it fails with a transient connection
+// error. Failover to the Secondary only happens once the retries are exhausted.
+func (db *RetryDB) SetConnRetries(n int, backoff time.Duration) {
+	db.Lock()
+	db.connRetries = n
+	db.connRetryBackoff = backoff
+	db.Unlock()
+}
+
+// SetDeadlockRetry immediately retries a query up to n times against the same
+// endpoint when it fails with a deadlock or serialization failure, before
+// considering failover.
+func (db *RetryDB) SetDeadlockRetry(n int) {
+	db.Lock()
+	db.deadlockRetries = n
+	db.Unlock()
+}
+
+// SetDeadlockClassifier replaces IsDeadlock as the check used by
+// SetDeadlockRetry. A nil f restores IsDeadlock.
+func (db *RetryDB) SetDeadlockClassifier(f func(error) bool) {
+	if f == nil {
+		f = IsDeadlock
+	}
+	db.Lock()
+	db.isDeadlock = f
+	db.Unlock()
+}
+
+// SetOnQuery sets a callback invoked after every Query and QueryContext with
+// the query tag (see WithQueryTag), the Source that served the query, the
+// total duration (including any failover) and the resulting error. Untagged
+// queries pass the SQL with whitespace collapsed, truncated to 128 bytes. It
+// is called synchronously so it should be cheap.
+func (db *RetryDB) SetOnQuery(f func(query string, source Source, d time.Duration, err error)) {
+	db.Lock()
+	db.onQuery = f
+	db.Unlock()
+}
+
+// SetOnProbe sets a callback invoked after each Primary query made once the
+// failover window has expired, reporting whether the probe succeeded or the
+// error (including a slow query) that re-opened the window. It is called
+// synchronously so it should be cheap.
+func (db *RetryDB) SetOnProbe(f func(success bool, err error)) {
+	db.Lock()
+	db.onProbe = f
+	db.Unlock()
+}
+
+// probed calls the SetOnProbe callback when the query was a probe
+func (db *RetryDB) probed(probe bool, err error) {
+	if !probe {
+		return
+	}
+	db.RLock()
+	onProbe := db.onProbe
+	db.RUnlock()
+	if onProbe != nil {
+		db.callback(context.Background(), "OnProbe", func() { onProbe(err == nil, err) })
+	}
+}
+
+// SetOnRetry sets a callback invoked before each retry of a query against the
+// same endpoint (see SetConnRetries and SetDeadlockRetry) with the retry
+// attempt, starting at 1, and the error being retried. Failover to the other
+// endpoint is not a retry.
+func (db *RetryDB) SetOnRetry(f func(query string, attempt int, err error, source Source)) {
+	db.Lock()
+	db.onRetry = f
+	db.Unlock()
+}
+
+// SetRecoverThreshold requires n consecutive successful Primary queries after
+// a failover window expires before the Primary is fully re-enabled and the
+// retry count reset. Until then every other query probes the Primary and the
+// rest stay on the Secondary. A failure before then re-opens the failover
+// window using the escalated retry count. The default is 1.
+func (db *RetryDB) SetRecoverThreshold(n int) {
+	db.Lock()
+	db.recoverThreshold = n
+	db.Unlock()
+}
+
+// SetSlowQueryAction sets what happens to a slow but successful Primary query.
+// Either way the failover window is opened. RetrySecondary pays for the
+// query a second time, so the caller sees the latency of both attempts.
+func (db *RetryDB) SetSlowQueryAction(action SlowQueryAction) {
+	db.Lock()
+	db.slowQueryAction = action
+	db.Unlock()
+}
+
+// SetSlowQueryEscalates sets whether slow query failovers escalate the retry
+// strategy like query errors do (the default). When false a slow query
+// disables the Primary for the first retry interval without incrementing the
+// retry count, so a few slow queries are not backed off as a hard outage.
+func (db *RetryDB) SetSlowQueryEscalates(escalates bool) {
+	db.Lock()
+	db.slowQueryFlat = !escalates
+	db.Unlock()
+}
+
+// PinSecondary routes all Query traffic to the Secondary for d, independent of
+// the error driven failover window, after which normal routing resumes. This
+// is useful for verifying the Secondary can handle full load. Contexts from
+// ForcePrimary still query the Primary. A zero d removes the pin.
+func (db *RetryDB) PinSecondary(d time.Duration) {
+	db.Lock()
+	db.pinnedUntil = time.Now().Add(d)
+	db.Unlock()
+}
+
+// SetQueryTimeout bounds the total time of a query, including both the Primary
+// attempt and any retry against the Secondary, to d. The Secondary attempt
+// only gets the remaining budget. Unlike a deadline on the caller's context,
+// a Primary query that runs out the timeout opens the failover window. As
+// with a context deadline, the returned Rows must be consumed before the
+// deadline. Zero (the default) disables the timeout.
+func (db *RetryDB) SetQueryTimeout(d time.Duration) {
+	db.Lock()
+	db.queryTimeout = d
+	db.Unlock()
+}
+
+// SetDualWrite enables mirroring of Exec statements to the Secondary after they
+// succeed against the Primary. This is best-effort: Secondary errors are logged
+// but never returned, and the Secondary can drift from the Primary. Off by
+// default.
+func (db *RetryDB) SetDualWrite(enabled bool) {
+	db.Lock()
+	db.dualWrite = enabled
+	db.Unlock()
+}
+
+// SetNoSecondaryBackoff rate limits queries to the Primary after a failure
+// when no Secondary is configured. The failing query returns its error, and
+// queries until the window determined by strategy expires fail fast with
+// ErrPrimaryBackoff. Off by default (nil strategy).
+func (db *RetryDB) SetNoSecondaryBackoff(strategy RetryStrategy) {
+	db.Lock()
+	db.noSecondaryRetry = strategy
+	db.Unlock()
+}
+
 func (r *RetryDB) SetMaxOpenConns(n int) {
-	if db, ok := r.Primary.(*sql.DB); ok {
+	r.Lock()
+	r.maxOpenConns = n
+	r.Unlock()
+	for _, db := range r.sqlDBs() {
 		db.SetMaxOpenConns(n)
 	}
-	if r.Secondary != nil {
-		if db, ok := r.Secondary.(*sql.DB); ok {
-			db.SetMaxOpenConns(n)
-		}
-	}
 }
 
 // SetMaxIdleConns propagates to the Primary and Secondary database connections
 func (r *RetryDB) SetMaxIdleConns(n int) {
-	if db, ok := r.Primary.(*sql.DB); ok {
-		db.SetMaxOpenConns(n)
+	r.Lock()
+	r.maxIdleConns = n
+	r.Unlock()
+	for _, db := range r.sqlDBs() {
+		db.SetMaxIdleConns(n)
+	}
+}
+
+// SetConnMaxLifetime propagates to the Primary and Secondary database
+// connections
+func (r *RetryDB) SetConnMaxLifetime(d time.Duration) {
+	r.Lock()
+	r.connMaxLifetime = d
+	r.Unlock()
+	for _, db := range r.sqlDBs() {
+		db.SetConnMaxLifetime(d)
+	}
+}
+
+// sqlDBs returns the Primary and Secondary that are a non-nil *sql.DB;
+// connection pool settings are a no-op for other Retryable implementations
+func (r *RetryDB) sqlDBs() []*sql.DB {
+	var dbs []*sql.DB
+	for _, endpoint := range []Retryable{r.Primary, r.Secondary} {
+		if db, ok := endpoint.(*sql.DB); ok && db != nil {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs
+}
+
+// Validate checks for nonsensical configuration; a nil Primary, a non-positive
+// max query time, a missing retry strategy, or more idle than open
+// connections (which database/sql silently caps).
+func (db *RetryDB) Validate() error {
+	var errs []error
+	if db.Primary == nil {
+		errs = append(errs, errors.New("retrydb: nil Primary"))
+	}
+	db.RLock()
+	defer db.RUnlock()
+	if db.maxQueryTime <= 0 {
+		errs = append(errs, fmt.Errorf("retrydb: max query time %s must be positive", db.maxQueryTime))
+	}
+	if db.retryStrategy == nil {
+		errs = append(errs, errors.New("retrydb: nil retry strategy"))
+	}
+	if db.maxOpenConns > 0 && db.maxIdleConns > db.maxOpenConns {
+		errs = append(errs, fmt.Errorf("retrydb: max idle conns %d exceeds max open conns %d", db.maxIdleConns, db.maxOpenConns))
+	}
+	if db.secondaryWeights != nil && len(db.secondaryWeights) != len(db.secondaries) {
+		errs = append(errs, fmt.Errorf("retrydb: %d secondary weights for %d secondaries", len(db.secondaryWeights), len(db.secondaries)))
 	}
-	if r.Secondary != nil {
-		if db, ok := r.Secondary.(*sql.DB); ok {
-			db.SetMaxIdleConns(n)
+	return errors.Join(errs...)
+}
+
+// WarmSecondary opens and pings n connections to Secondary so the pool is not
+// cold when a failover happens. Connections beyond the Secondary's
+// MaxIdleConns are closed once warm-up completes.
+func (db *RetryDB) WarmSecondary(ctx context.Context, n int) error {
+	if db.Secondary == nil {
+		return nil
+	}
+	c, ok := db.Secondary.(conner)
+	if !ok {
+		return pingContext(ctx, db.Secondary)
+	}
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		conn, err := c.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		if err := conn.PingContext(ctx); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
 // Transaction against Primary
-func (db *RetryDB) Begin() (*sql.Tx, error) { return db.Primary.Begin() }
+func (db *RetryDB) Begin() (*sql.Tx, error) { return db.BeginTx(context.Background(), nil) }
 
-// Exec against Primary
+// Exec against Primary (and Secondary when SetDualWrite is enabled).
+//
+// Exec is never retried or failed over, regardless of SetConnRetries or
+// SetDeadlockRetry, so a statement that may have been applied is not run a
+// second time. Primary errors are returned to the caller as is. They do not
+// open the failover window unless the Primary is read-only (IsReadOnlyError),
+// in which case reads also move to the Secondary. (database/sql itself only
+// retries a statement when the driver returns driver.ErrBadConn, which
+// guarantees it was not executed.)
 func (db *RetryDB) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return db.Primary.Exec(query, args...)
+	if err := db.validateArgs(query, args); err != nil {
+		return nil, err
+	}
+	result, err := db.primaryExec(query, args...)
+	if IsReadOnlyError(err) && db.secondaryActive() {
+		db.updateRetry(context.Background(), ReasonReadOnly, fmt.Errorf("exec errored with %s. primary is read-only. sql:%s", err, db.formatQuery(query, args)))
+	}
+	if err == nil && db.secondaryActive() {
+		db.RLock()
+		dualWrite := db.dualWrite
+		db.RUnlock()
+		if dualWrite {
+			if _, serr := db.Secondary.Exec(query, args...); serr != nil {
+				db.logger(context.Background()).Printf("dual write to secondary errored with %s. sql:%s", serr, db.formatQuery(query, args))
+			}
+		}
+	}
+	return result, err
 }
 
 // Driver of Primary
 func (db *RetryDB) Driver() driver.Driver { return db.Primary.Driver() }
 
-// returns error if both Primary and Secondary fail pings
+// returns error if both Primary and Secondary fail pings. A failed Primary
+// ping opens the failover window when there is a Secondary.
 func (db *RetryDB) Ping() error {
 	err := db.Primary.Ping()
-	if err != nil && db.Secondary == nil {
+	if !db.secondaryActive() {
 		return err
 	}
-	if db.Secondary != nil {
-		return db.Secondary.Ping()
+	if err != nil {
+		db.updateRetry(context.Background(), ReasonPing, fmt.Errorf("ping errored with %s", err))
+	}
+	return db.Secondary.Ping()
+}
+
+// DisablePrimary opens the failover window, as if a query against the
+// Primary had failed, so reads move to the Secondary. It has no effect
+// without a Secondary.
+func (db *RetryDB) DisablePrimary() {
+	if !db.secondaryActive() {
+		return
+	}
+	db.updateRetry(context.Background(), ReasonManual, errors.New("disabled manually"))
+}
+
+// ReconnectPrimary pings the Primary and, if it is healthy, immediately
+// re-enables it, ending any failover window, rather than waiting for the
+// window to expire.
+func (db *RetryDB) ReconnectPrimary(ctx context.Context) error {
+	if err := pingContext(ctx, db.Primary); err != nil {
+		return err
+	}
+	if c, _ := db.stmtCacheFor(db.Primary); c != nil {
+		c.invalidate(db.Primary)
+	}
+	if db.primaryDisabled() {
+		db.updateRetry(ctx, ReasonNone, nil)
 	}
-	return err
+	return nil
+}
+
+// PrimaryDB returns the Primary *sql.DB, or false if Primary is a custom Retryable
+func (db *RetryDB) PrimaryDB() (*sql.DB, bool) {
+	p, ok := db.Primary.(*sql.DB)
+	return p, ok && p != nil
 }
 
+// SecondaryDB returns the Secondary *sql.DB, or false if Secondary is nil or a custom Retryable
+func (db *RetryDB) SecondaryDB() (*sql.DB, bool) {
+	s, ok := db.Secondary.(*sql.DB)
+	return s, ok && s != nil
+}
+
+// HasSecondary returns true if a Secondary is configured
+func (db *RetryDB) HasSecondary() bool { return db.Secondary != nil }
+
+// SecondaryHealthy returns true if a Secondary is configured and responds to a ping
+func (db *RetryDB) SecondaryHealthy(ctx context.Context) bool {
+	return db.Secondary != nil && pingContext(ctx, db.Secondary) == nil
+}
+
+// Prepare creates a prepared statement on the Primary. Statements do not fail
+// over; see SetStmtCacheSize to reuse prepared statements with failover.
 func (db *RetryDB) Prepare(query string) (*sql.Stmt, error) {
-	panic("not implemented")
+	p, ok := db.Primary.(preparer)
+	if !ok {
+		return nil, errors.New("retrydb: primary does not support Prepare")
+	}
+	return p.PrepareContext(context.Background(), query)
 }
 
 func getFatalError(a error, r *sql.Rows) error {
-	if a != nil && a != sql.ErrNoRows {
+	if IsFatalError(a) {
 		return a
 	}
 	if r == nil {
 		return nil
 	}
-	if r.Err() != nil && r.Err() != sql.ErrNoRows {
-		return r.Err()
+	if err := r.Err(); IsFatalError(err) {
+		return err
 	}
 	return nil
 }
 
+// Query against Primary, falling back to Secondary on error or while the Primary is disabled.
+// When both fail the returned error joins the Primary and Secondary errors.
+// args, including sql.NamedArg values, are passed unchanged to every attempt.
 func (db *RetryDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	if db.Secondary == nil {
-		return db.Primary.Query(query, args...)
+	rows, _, err := db.query(context.Background(), query, args...)
+	return rows, err
+}
+
+// QueryServedBy is Query that also returns the Source that served the query
+func (db *RetryDB) QueryServedBy(query string, args ...interface{}) (*sql.Rows, Source, error) {
+	rows, info, err := db.query(context.Background(), query, args...)
+	return rows, info.Source, err
+}
+
+// QueryPrimary always queries against Primary regardless of any failover
+// window; errors are returned without retrying against Secondary. Use it for
+// reads that must observe prior writes.
+func (db *RetryDB) QueryPrimary(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.primaryQuery(context.Background(), query, args...)
+}
+
+// QueryInfo describes how a query was served; see QueryWithInfo
+type QueryInfo struct {
+	Attempts int // queries sent to any endpoint, including retries
+	Source   Source
+	Failover bool // the query was re-run on the other endpoint after an error
+	Duration time.Duration
+	Stale    bool // served by the Secondary so it may lag the Primary
+}
+
+func (db *RetryDB) query(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, info QueryInfo, err error) {
+	start := time.Now()
+	if err := db.validateArgs(query, args); err != nil {
+		return nil, QueryInfo{}, err
 	}
+	db.RLock()
+	timeout := db.queryTimeout
+	db.RUnlock()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, errQueryTimeout)
+		var closers *rowsClosers
+		ctx, closers = withRowsClosers(ctx)
+		// the returned rows share the deadline, so only release it once they
+		// are closed
+		defer func() { closers.onClose(rows, cancel) }()
+	}
+	db.RLock()
+	onQuery, onTiming := db.onQuery, db.onQueryTiming
+	db.RUnlock()
+	var timing *QueryTiming
+	if onTiming != nil {
+		timing = new(QueryTiming)
+		ctx = context.WithValue(ctx, queryTimingKey, timing)
+	}
+	rows, info, err = db.route(ctx, query, args...)
+	info.Duration = time.Since(start)
+	info.Stale = info.Source == SourceSecondary
+	db.checkPoolSaturation(ctx, info.Source)
+	if onQuery != nil {
+		db.callback(ctx, "OnQuery", func() {
+			onQuery(db.queryTag(ctx, query), info.Source, info.Duration, getFatalError(err, rows))
+		})
+	}
+	if onTiming != nil {
+		timing.Total = info.Duration
+		db.callback(ctx, "OnQueryTiming", func() {
+			onTiming(db.queryTag(ctx, query), info.Source, *timing, getFatalError(err, rows))
+		})
+	}
+	return rows, info, err
+}
 
-	// if already in retry; just query the Secondary
+// route runs query against the Primary or Secondary, updating the failover
+// window as needed
+func (db *RetryDB) route(ctx context.Context, query string, args ...interface{}) (*sql.Rows, QueryInfo, error) {
+	hint := db.routeHint(query)
+	if isForcePrimary(ctx) || hint == SourcePrimary {
+		rows, err := db.primaryQueryRetry(ctx, query, args...)
+		return rows, QueryInfo{Source: SourcePrimary}, err
+	}
+	hasSecondary := db.secondaryActive()
+	if hint == SourceSecondary && hasSecondary {
+		rows, source, err := db.secondaryQueryRetry(ctx, query, args...)
+		return rows, QueryInfo{Source: source}, err
+	}
+	if c, ok := consistencyFrom(ctx); ok && hasSecondary {
+		return db.consistencyQuery(ctx, c, query, args...)
+	}
+	if !hasSecondary {
+		return db.primaryOnlyQuery(ctx, query, args...)
+	}
 	db.RLock()
-	until := db.retryUntil
+	pref := db.readPreference
 	db.RUnlock()
+	switch pref {
+	case PrimaryOnly:
+		rows, err := db.primaryQueryRetry(ctx, query, args...)
+		return rows, QueryInfo{Source: SourcePrimary}, err
+	case SecondaryOnly:
+		rows, source, err := db.secondaryQueryRetry(ctx, query, args...)
+		return rows, QueryInfo{Source: source}, err
+	case SecondaryPreferred:
+		return db.secondaryPreferredQuery(ctx, query, args...)
+	}
+
 	start := time.Now()
-	if start.Before(until) {
+	if db.pinned(start) {
+		rows, source, err := db.secondaryQueryRetry(ctx, query, args...)
+		return rows, QueryInfo{Source: source}, err
+	}
+
+	// if already in retry; just query the Secondary
+	if db.retrying(start) || db.probeAsync(start) || db.recovering() {
+		if db.secondaryOpen(start) {
+			return nil, QueryInfo{Source: SourceNone}, ErrNoHealthyEndpoint
+		}
 		atomic.AddUint32(&db.secondaryQueries, 1)
-		return db.Secondary.Query(query, args...)
+		rows, source, err := db.failoverQuery(ctx, query, args)
+		return rows, QueryInfo{Source: source}, err
 	}
 
-	rows, err := db.Primary.Query(query, args...)
+	info := QueryInfo{Source: SourcePrimary}
+	// a Primary query after the failover window expires probes for recovery
+	probe := db.primaryDisabled()
+	db.RLock()
+	hedgeDelay := db.hedgeDelay
+	db.RUnlock()
+	var rows *sql.Rows
+	var err error
+	if hedgeDelay > 0 {
+		var source Source
+		var primaryErr error
+		rows, source, primaryErr, err = db.hedge(ctx, hedgeDelay, query, args)
+		if source == SourceSecondary {
+			db.hedgedPrimaryError(ctx, primaryErr, query, args, time.Since(start))
+			return rows, QueryInfo{Source: SourceSecondary}, err
+		}
+	} else {
+		rows, err = db.primaryQueryRetry(ctx, query, args...)
+	}
 	// it's important to peek into Err here
-	if ferr := getFatalError(err, rows); ferr != nil {
-		db.updateRetry(fmt.Errorf("query errored with %s. retrying against secondary. sql:%q", ferr, query))
-		rows, err = db.Secondary.Query(query, args...)
+	ferr, stands := db.primaryError(ctx, err, rows, query, time.Since(start))
+	if stands {
+		return rows, info, err
+	}
+	failover := db.recordPrimaryOutcome(ferr != nil)
+	if ferr != nil {
+		db.probed(probe, ferr)
+		if failover {
+			db.updateRetry(ctx, ReasonQueryError, fmt.Errorf("query errored with %s. retrying against secondary. sql:%s", ferr, db.formatQuery(query, args)))
+		}
+		if rows != nil {
+			rows.Close()
+		}
+		if db.secondaryOpen(time.Now()) {
+			return nil, QueryInfo{Source: SourceNone}, errors.Join(ferr, ErrNoHealthyEndpoint)
+		}
+		var source Source
+		rows, source, err = db.failoverQuery(ctx, query, args)
+		info = QueryInfo{Source: source, Failover: source == SourceSecondary}
+		if err != nil && source == SourceSecondary {
+			// keep both errors inspectable with errors.Is and errors.As
+			err = errors.Join(ferr, err)
+		}
 	} else {
 		// query succeeded
 		queryDuration := time.Since(start)
+		slow := queryDuration > db.queryTimeLimit(ctx, start)
+		var slowErr error
+		if slow {
+			slowErr = fmt.Errorf("query exceeded allowed limit (%s). sql:%s", queryDuration, db.formatQuery(query, args))
+			if fail, ok := db.decideFailover(ctx, slowErr, query, queryDuration); ok {
+				slow = fail
+			}
+		}
+		if slow {
+			// but it took too long
+			db.probed(probe, slowErr)
+			db.updateRetry(ctx, ReasonSlowQuery, slowErr)
+			db.maybeExplain(query, args)
+			db.RLock()
+			action := db.slowQueryAction
+			db.RUnlock()
+			if action == RetrySecondary {
+				rows.Close()
+				var source Source
+				rows, source, err = db.secondaryQueryRetry(ctx, query, args...)
+				info = QueryInfo{Source: source, Failover: source == SourceSecondary}
+			}
+		} else {
+			db.RLock()
+			soft, hard := db.softQueryTime, db.maxQueryTime
+			db.RUnlock()
+			if soft > 0 && soft < hard && queryDuration > soft {
+				db.logger(ctx).Printf("query exceeded soft limit (%s). sql:%s", queryDuration, db.formatQuery(query, args))
+			}
+			db.probed(probe, nil)
+			db.recordPrimarySuccess(query, args)
+			if db.primaryDisabled() {
+				db.primaryRecovered(ctx)
+			}
+			db.maybeShadow(query, args)
+		}
+	}
+	return rows, info, err
+}
+
+// queryTimeLimit returns the slow query threshold for a query started at
+// start; the max query time, the SetAdaptiveQueryTime threshold or the time
+// remaining until the ctx deadline, whichever is shorter
+func (db *RetryDB) queryTimeLimit(ctx context.Context, start time.Time) time.Duration {
+	db.RLock()
+	limit := db.maxQueryTime
+	db.RUnlock()
+	if adaptive, ok := db.adaptiveQueryTime(); ok && adaptive < limit {
+		limit = adaptive
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := deadline.Sub(start); remaining < limit {
+			limit = remaining
+		}
+	}
+	return limit
+}
+
+// primaryOnlyQuery queries the Primary when there is no Secondary to fail over to
+func (db *RetryDB) primaryOnlyQuery(ctx context.Context, query string, args ...interface{}) (*sql.Rows, QueryInfo, error) {
+	db.RLock()
+	backoff := db.noSecondaryRetry != nil
+	db.RUnlock()
+	if !backoff {
+		rows, err := db.primaryQueryRetry(ctx, query, args...)
+		return rows, QueryInfo{Source: SourcePrimary}, err
+	}
+	if db.retrying(time.Now()) {
+		return nil, QueryInfo{Source: SourceNone}, ErrPrimaryBackoff
+	}
+	rows, err := db.primaryQueryRetry(ctx, query, args...)
+	if ferr := db.fatalError(SourcePrimary, err, rows); ferr != nil {
+		if callerContextError(ctx, ferr) {
+			return rows, QueryInfo{Source: SourcePrimary}, err
+		}
+		db.updateRetry(ctx, ReasonQueryError, fmt.Errorf("query errored with %s. no secondary configured. sql:%s", ferr, db.formatQuery(query, args)))
+	} else if db.primaryDisabled() {
+		db.updateRetry(ctx, ReasonNone, nil)
+	}
+	return rows, QueryInfo{Source: SourcePrimary}, err
+}
+
+// pinned returns true if reads are pinned to the Secondary at time t
+func (db *RetryDB) pinned(t time.Time) bool {
+	db.RLock()
+	until := db.pinnedUntil
+	db.RUnlock()
+	return t.Before(until)
+}
+
+// retrying returns true if the Primary is disabled at time t
+func (db *RetryDB) retrying(t time.Time) bool {
+	db.RLock()
+	until := db.retryUntil
+	db.RUnlock()
+	return t.Before(until)
+}
+
+type queryFunc func(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+
+func (db *RetryDB) primaryQueryRetry(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.retryQuery(ctx, SourcePrimary, db.primaryQuery, query, args...)
+}
+
+// secondaryQueryRetry runs a read routed to the Secondary, returning the
+// Source that served it; the Primary when the Secondary lags too far (with
+// SetReplicaLagFallback) or is too stale for WithMaxStaleness
+func (db *RetryDB) secondaryQueryRetry(ctx context.Context, query string, args ...interface{}) (*sql.Rows, Source, error) {
+	ctx, secondary := db.withSecondary(ctx)
+	if err := db.checkReplicaLag(ctx, secondary); err != nil {
 		db.RLock()
-		tooLong := queryDuration > db.maxQueryTime
+		fallback := db.replicaLagFallback
 		db.RUnlock()
-		if tooLong {
-			// but it took too long
-			db.updateRetry(fmt.Errorf("query exceeded allowed limit (%s). sql:%q", queryDuration, query))
-		} else if atomic.LoadUint32(&db.retryCount) > 0 {
-			db.updateRetry(nil)
+		if fallback {
+			return db.primaryFallback(ctx, err, query, args)
+		}
+		return nil, SourceSecondary, err
+	}
+	if db.tooStale(ctx, secondary) {
+		return db.primaryFallback(ctx, fmt.Errorf("%w (older than max staleness)", ErrReplicaLag), query, args)
+	}
+	if err := db.waitForReplica(ctx, secondary); err != nil {
+		return nil, SourceSecondary, err
+	}
+	rows, err := db.retryQuery(ctx, SourceSecondary, db.secondaryQuery, query, args...)
+	if !errors.Is(err, ErrSecondaryOverloaded) {
+		db.recordSecondaryOutcome(db.fatalError(SourceSecondary, err, rows) != nil)
+	}
+	return rows, SourceSecondary, err
+}
+
+// isDeadlockError runs the deadlock classifier isDeadlock, treating a panic as
+// not a deadlock
+func (db *RetryDB) isDeadlockError(ctx context.Context, isDeadlock func(error) bool, err error) (deadlock bool) {
+	db.callback(ctx, "deadlock classifier", func() { deadlock = isDeadlock(err) })
+	return deadlock
+}
+
+// retryQuery runs query retrying against the same endpoint on transient
+// connection errors (SetConnRetries) and deadlocks (SetDeadlockRetry)
+func (db *RetryDB) retryQuery(ctx context.Context, source Source, run queryFunc, query string, args ...interface{}) (*sql.Rows, error) {
+	db.RLock()
+	connRetries, backoff := db.connRetries, db.connRetryBackoff
+	deadlockRetries, isDeadlock := db.deadlockRetries, db.isDeadlock
+	onRetry := db.onRetry
+	db.RUnlock()
+	rows, err := run(ctx, query, args...)
+	for attempt := 1; ; attempt++ {
+		ferr := getFatalError(err, rows)
+		var wait time.Duration
+		switch {
+		case ferr == nil:
+			return rows, err
+		case connRetries > 0 && isConnError(ferr):
+			connRetries--
+			wait = backoff
+		case deadlockRetries > 0 && db.isDeadlockError(ctx, isDeadlock, ferr):
+			deadlockRetries--
+		default:
+			return rows, err
+		}
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return rows, err
+			case <-t.C:
+			}
+		}
+		if rows != nil {
+			rows.Close()
+		}
+		if onRetry != nil {
+			db.callback(ctx, "OnRetry", func() { onRetry(query, attempt, ferr, source) })
 		}
+		rows, err = run(ctx, query, args...)
 	}
+}
+
+func (db *RetryDB) primaryQuery(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	countAttempt(ctx)
+	start := time.Now()
+	rows, err := closingQuery(ctx, nil, func(ctx context.Context) (*sql.Rows, error) {
+		return db.endpointQuery(ctx, db.Primary, query, args...)
+	})
+	d := time.Since(start)
+	db.primaryLatency.observe(d)
+	db.observeLatency(d)
+	db.countError(ctx, &db.primaryErrors, SourcePrimary, err, rows)
+	return rows, err
+}
+
+func (db *RetryDB) secondaryQuery(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	release, err := db.acquireSecondary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, secondary := db.withSecondary(ctx)
+	conn, err := db.sessionConn(ctx, secondary)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	countAttempt(ctx)
+	start := time.Now()
+	// the slot is held until the rows are closed
+	rows, err := closingQuery(ctx, release, func(ctx context.Context) (*sql.Rows, error) {
+		if conn != nil {
+			return conn.QueryContext(ctx, query, args...)
+		}
+		return db.endpointQuery(ctx, secondary, query, args...)
+	})
+	db.secondaryLatency.observe(time.Since(start))
+	db.countError(ctx, &db.secondaryErrors, SourceSecondary, err, rows)
 	return rows, err
 }
 
-func (db *RetryDB) updateRetry(err error) {
+// countError increments counter when a query against source failed with a
+// fatal error other than a context error from the caller
+func (db *RetryDB) countError(ctx context.Context, counter *atomic.Uint64, source Source, err error, rows *sql.Rows) {
+	if ferr := db.fatalError(source, err, rows); ferr != nil && !callerContextError(ctx, ferr) {
+		counter.Add(1)
+	}
+}
+
+// primaryDisabled returns true if the Primary has failed since it was last
+// re-enabled, whether or not the failover window has expired
+func (db *RetryDB) primaryDisabled() bool {
+	return atomic.LoadUint32(&db.retryCount) > 0 || atomic.LoadUint32(&db.slowRetryCount) > 0
+}
+
+// recovering returns true if a query after the failover window expires should
+// stay on the Secondary because the previous query probed the Primary and
+// SetRecoverThreshold probes have not yet succeeded
+func (db *RetryDB) recovering() bool {
+	if !db.primaryDisabled() {
+		return false
+	}
+	db.RLock()
+	threshold := db.recoverThreshold
+	db.RUnlock()
+	if threshold <= 1 {
+		return false
+	}
+	db.Lock()
+	defer db.Unlock()
+	db.recoverQueries++
+	return db.recoverQueries%2 == 0
+}
+
+// primaryRecovered records a successful Primary query after a failover,
+// re-enabling the Primary once SetRecoverThreshold is reached
+func (db *RetryDB) primaryRecovered(ctx context.Context) {
+	db.Lock()
+	db.recoverSuccesses++
+	recovered := db.recoverSuccesses >= db.recoverThreshold
+	db.Unlock()
+	if recovered {
+		db.updateRetry(ctx, ReasonNone, nil)
+	}
+}
+
+func (db *RetryDB) updateRetry(ctx context.Context, reason FailoverReason, err error) {
+	logger := db.logger(ctx)
 	db.Lock()
+	db.recoverSuccesses = 0
+	db.recoverQueries = 0
 	if err == nil {
-		db.retryUntil = time.Now()
+		db.retryUntil = time.Time{}
 		atomic.StoreUint32(&db.retryCount, 0)
+		atomic.StoreUint32(&db.slowRetryCount, 0)
 		atomic.StoreUint32(&db.secondaryQueries, 0)
-		log.Printf("re-enabling master. %d queries run against secondary", atomic.LoadUint32(&db.secondaryQueries))
+		db.flushThrottledLogs(logger, time.Now())
+		logger.Printf("re-enabling master. %d queries run against secondary", atomic.LoadUint32(&db.secondaryQueries))
+		db.emit(FailoverEvent{Type: PrimaryEnabled, Time: time.Now()})
+		db.stopOutageTimer()
+		db.stopProbeLoop()
 	} else {
-		until := time.Now().Add(db.retryStrategy(db.retryCount))
-		if db.retryCount == 0 {
-			log.Printf("disabling master until %s. %s", until, err)
+		now := time.Now()
+		interval := db.retryInterval()
+		escalate := reason != ReasonSlowQuery || !db.slowQueryFlat
+		if !escalate {
+			interval = db.activeRetryStrategy()(0)
+		}
+		until := now.Add(db.jitter(interval))
+		if db.retryCount == 0 && db.slowRetryCount == 0 {
+			db.totalFailovers.Add(1)
+			db.failoverReasons[reason].Add(1)
+			logger.Printf("disabling master until %s. %s", until, err)
+			db.emit(FailoverEvent{Type: PrimaryDisabled, Time: now, Reason: reason, Err: err})
+			db.startOutageTimer()
+			db.startProbeLoop()
+			db.validatedSecondaries = nil
+		} else if db.throttleLog(logger, now) {
+			logger.Printf("updating master disabled until %s. %s", until, err)
+		}
+		if escalate {
+			atomic.AddUint32(&db.retryCount, 1)
 		} else {
-			log.Printf("updating master disabled until %s. %s", until, err)
+			atomic.AddUint32(&db.slowRetryCount, 1)
 		}
-		db.retryCount += 1
 		db.retryUntil = until
+		if !until.After(now) {
+			// an empty window must not catch queries that started before it was set
+			db.retryUntil = time.Time{}
+		}
 	}
 	db.Unlock()
 }
@@ -199,27 +1235,48 @@ func (db *RetryDB) updateRetry(err error) {
 // QueryRow always return a non-nil value. Errors are deferred until
 // Row's Scan method is called.
 func (db *RetryDB) QueryRow(query string, args ...interface{}) *Row {
-	rows, err := db.Query(query, args...)
-	return &Row{rows: rows, err: err}
+	row, _ := db.queryRow(context.Background(), query, args...)
+	return row
+}
+
+// QueryRowServedBy is QueryRow that also returns the Source that served the
+// query
+func (db *RetryDB) QueryRowServedBy(query string, args ...interface{}) (*Row, Source) {
+	return db.queryRow(context.Background(), query, args...)
 }
 
+// Close closes the Primary and Secondary, returning their errors joined.
+// Calling Close again returns nil.
 func (db *RetryDB) Close() error {
-	err := db.Primary.Close()
-	var sErr error
+	if !db.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	db.closeEvents()
+	db.Lock()
+	db.stopProbeLoop()
+	stmts := db.stmts
+	db.Unlock()
+	if stmts != nil {
+		stmts.invalidate(nil)
+	}
+	errs := []error{db.Primary.Close()}
 	if db.Secondary != nil {
-		sErr = db.Secondary.Close()
+		errs = append(errs, db.Secondary.Close())
 	}
-	if err != nil {
-		return err
+	for _, s := range db.secondaries {
+		if s != db.Secondary {
+			errs = append(errs, s.Close())
+		}
 	}
-	return sErr
+	return errors.Join(errs...)
 }
 
 // Row is the result of calling QueryRow to select a single row.
 type Row struct {
 	// One of these two will be non-nil:
-	err  error // deferred error for easy chaining
-	rows *sql.Rows
+	err      error // deferred error for easy chaining
+	rows     *sql.Rows
+	advanced bool // rows is already on the first row
 }
 
 // Scan copies the columns from the matched row into the values
@@ -251,7 +1308,7 @@ func (r *Row) Scan(dest ...interface{}) error {
 		}
 	}
 
-	if !r.rows.Next() {
+	if !r.first() {
 		if err := r.rows.Err(); err != nil {
 			return err
 		}
@@ -269,6 +1326,54 @@ func (r *Row) Scan(dest ...interface{}) error {
 	return nil
 }
 
+// ScanRaw copies the columns from the matched row into buf, reusing its
+// capacity, and points each dest at its column within the returned buffer. A
+// NULL column sets dest to nil. This avoids the per-column allocation of
+// scanning into *[]byte when buf is reused across calls.
+//
+// The dest slices alias the returned buffer and are only valid until that
+// buffer is modified or passed to another ScanRaw call.
+func (r *Row) ScanRaw(buf []byte, dest ...*[]byte) ([]byte, error) {
+	if r.err != nil {
+		return buf, r.err
+	}
+	defer r.rows.Close()
+	if !r.first() {
+		if err := r.rows.Err(); err != nil {
+			return buf, err
+		}
+		return buf, sql.ErrNoRows
+	}
+	raw := make([]sql.RawBytes, len(dest))
+	ptrs := make([]interface{}, len(dest))
+	for i := range raw {
+		ptrs[i] = &raw[i]
+	}
+	if err := r.rows.Scan(ptrs...); err != nil {
+		return buf, err
+	}
+	buf = buf[:0]
+	for _, b := range raw {
+		buf = append(buf, b...)
+	}
+	// buf may have been reallocated while appending so set dest afterwards
+	var offset int
+	for i, b := range raw {
+		if b == nil {
+			*dest[i] = nil
+			continue
+		}
+		end := offset + len(b)
+		*dest[i] = buf[offset:end:end]
+		offset = end
+	}
+	// Make sure the query can be processed to completion with no errors.
+	if err := r.rows.Close(); err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
 // Retryable is the Interface for something RetryDB can retry
 type Retryable interface {
 	Begin() (*sql.Tx, error)
@@ -0,0 +1,913 @@
+package retrydb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestQueryPrimary(t *testing.T) {
+	db, p, s := newTestDB(t)
+
+	// open a failover window
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if _, err := db.QueryPrimary("select 1"); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	p.setErr(nil)
+	before := s.queryCount()
+	rows, err := db.QueryPrimary("select 1")
+	if v := scanValue(t, rows, err); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if s.queryCount() != before {
+		t.Fatalf("QueryPrimary queried the secondary")
+	}
+}
+
+func TestWarmSecondary(t *testing.T) {
+	p := newFakeServer(t, "primary")
+	s := newFakeServer(t, "secondary")
+	db, err := Open(fakeDriverName, p.name, fakeDriverName, s.name, WithWarmSecondary(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := db.Stats().Secondary.OpenConnections; n != 2 {
+		t.Fatalf("got %d open secondary connections expected 2", n)
+	}
+
+	db.Secondary.(*sql.DB).SetMaxIdleConns(5)
+	if err := db.WarmSecondary(context.Background(), 5); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats().Secondary.OpenConnections; n != 5 {
+		t.Fatalf("got %d open secondary connections expected 5", n)
+	}
+}
+
+func TestConnRetries(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetConnRetries(2, time.Millisecond)
+	var attempts int
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, nil, &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+		}
+		return []string{"source"}, [][]driver.Value{{p.name}}, nil
+	})
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if attempts != 2 || s.queryCount() != 0 || db.Stats().RetryCount != 0 {
+		t.Fatalf("got %d attempts, %d secondary queries, stats %+v", attempts, s.queryCount(), db.Stats())
+	}
+
+	// non-transient errors fail over immediately
+	p.setHandler(nil)
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := p.queryCount(); n != 3 {
+		t.Fatalf("got %d primary queries expected 3", n)
+	}
+}
+
+func TestSecondaryHealthy(t *testing.T) {
+	ctx := context.Background()
+	p := newFakeServer(t, "primary")
+	primary, _ := sql.Open(fakeDriverName, p.name)
+	db := OpenWithDB(primary, nil)
+	if db.HasSecondary() || db.SecondaryHealthy(ctx) {
+		t.Fatal("expected no secondary")
+	}
+
+	db, _, s := newTestDB(t)
+	if !db.HasSecondary() || !db.SecondaryHealthy(ctx) {
+		t.Fatal("expected healthy secondary")
+	}
+	s.setErr(errTest)
+	if db.SecondaryHealthy(ctx) {
+		t.Fatal("expected unhealthy secondary")
+	}
+}
+
+func TestDualWrite(t *testing.T) {
+	db, p, s := newTestDB(t)
+	if _, err := db.Exec("insert into t values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if p.execCount() != 1 || s.execCount() != 0 {
+		t.Fatalf("got primary:%d secondary:%d execs", p.execCount(), s.execCount())
+	}
+
+	db.SetDualWrite(true)
+	if _, err := db.Exec("insert into t values (2)"); err != nil {
+		t.Fatal(err)
+	}
+	s.setErr(errTest)
+	if _, err := db.Exec("insert into t values (3)"); err != nil {
+		t.Fatalf("secondary error was returned %s", err)
+	}
+	if p.execCount() != 3 || s.execCount() != 2 {
+		t.Fatalf("got primary:%d secondary:%d execs", p.execCount(), s.execCount())
+	}
+}
+
+func TestExecNoRetry(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetDualWrite(true)
+	db.SetConnRetries(3, 0)
+	db.SetDeadlockRetry(3)
+
+	for _, perr := range []error{errTest, driver.ErrBadConn, syscall.ECONNRESET, sqlStateError("40001")} {
+		p.Lock()
+		p.execs = nil
+		p.Unlock()
+		p.setErr(perr)
+		if _, err := db.Exec("insert into t values (1)"); err == nil {
+			t.Fatalf("%v: expected error", perr)
+		}
+		// driver.ErrBadConn is retried by database/sql since it guarantees the
+		// statement did not run
+		if n := p.execCount(); n != 1 && perr != driver.ErrBadConn {
+			t.Fatalf("%v: got %d primary execs expected 1", perr, n)
+		}
+	}
+	if n := s.execCount(); n != 0 {
+		t.Fatalf("got %d secondary execs expected 0", n)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+}
+
+func TestNoSecondaryBackoff(t *testing.T) {
+	p := newFakeServer(t, "primary")
+	primary, _ := sql.Open(fakeDriverName, p.name)
+	db := OpenWithDB(primary, nil)
+	defer db.Close()
+
+	// without a backoff every query reaches the primary
+	p.setErr(errTest)
+	for i := 0; i < 2; i++ {
+		if _, err := db.Query("select 1"); err != errTest {
+			t.Fatalf("got %v expected %v", err, errTest)
+		}
+	}
+	if n := p.queryCount(); n != 2 {
+		t.Fatalf("got %d primary queries expected 2", n)
+	}
+
+	db.SetNoSecondaryBackoff(func(uint32) time.Duration { return time.Minute })
+	if _, err := db.Query("select 1"); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if _, err := db.Query("select 1"); err != ErrPrimaryBackoff {
+		t.Fatalf("got %v expected %v", err, ErrPrimaryBackoff)
+	}
+	if n := p.queryCount(); n != 3 {
+		t.Fatalf("got %d primary queries expected 3", n)
+	}
+
+	// with a secondary configured the backoff does not apply
+	db, p, s := newTestDB(t)
+	db.SetNoSecondaryBackoff(func(uint32) time.Duration { return 0 })
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if v := queryValue(t, db, "select 1"); v != s.name || p.queryCount() != 1 {
+		t.Fatalf("got %q with %d primary queries", v, p.queryCount())
+	}
+}
+
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sqlstate " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestDeadlockRetry(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetDeadlockRetry(1)
+	var attempts int
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, nil, errors.New("Error 1213 (40001): Deadlock found when trying to get lock; try restarting transaction")
+		}
+		return []string{"source"}, [][]driver.Value{{p.name}}, nil
+	})
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if attempts != 2 || s.queryCount() != 0 {
+		t.Fatalf("got %d attempts and %d secondary queries", attempts, s.queryCount())
+	}
+
+	// a custom classifier
+	db.SetDeadlockClassifier(func(err error) bool { return err == errTest })
+	attempts = 0
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, nil, errTest
+		}
+		return []string{"source"}, [][]driver.Value{{p.name}}, nil
+	})
+	if v := queryValue(t, db, "select 1"); v != p.name || attempts != 2 {
+		t.Fatalf("got %q after %d attempts", v, attempts)
+	}
+
+	// nil restores IsDeadlock, so errTest is not retried
+	db.SetDeadlockClassifier(nil)
+	attempts = 0
+	if v := queryValue(t, db, "select 1"); v != s.name || attempts != 1 {
+		t.Fatalf("got %q after %d attempts", v, attempts)
+	}
+
+	if !IsDeadlock(fmt.Errorf("wrapped: %w", sqlStateError("40P01"))) || IsDeadlock(sqlStateError("08006")) {
+		t.Fatal("unexpected IsDeadlock classification")
+	}
+}
+
+func TestClone(t *testing.T) {
+	db, p, s := newTestDB(t)
+	c := db.Clone()
+	if c.Primary != db.Primary || c.Secondary != db.Secondary {
+		t.Fatal("expected shared pools")
+	}
+
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	p.setErr(nil)
+	if v := queryValue(t, c, "select 1"); v != p.name {
+		t.Fatalf("clone got %q expected %q", v, p.name)
+	}
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if db.Stats().RetryCount != 1 || c.Stats().RetryCount != 0 {
+		t.Fatalf("got retry count %d clone %d", db.Stats().RetryCount, c.Stats().RetryCount)
+	}
+}
+
+func TestSetMaxIdleConns(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	db.SetMaxIdleConns(2)
+	st := db.Stats()
+	// only the idle limit changes on either endpoint
+	if st.Primary.MaxOpenConnections != 0 || st.Secondary.MaxOpenConnections != 0 {
+		t.Fatalf("got max open conns %d/%d expected unlimited", st.Primary.MaxOpenConnections, st.Secondary.MaxOpenConnections)
+	}
+}
+
+func TestOnQuery(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	var source Source
+	var d time.Duration
+	var qerr error
+	db.SetOnQuery(func(query string, s Source, duration time.Duration, err error) {
+		source, d, qerr = s, duration, err
+	})
+
+	p.setDelay(5 * time.Millisecond)
+	queryValue(t, db, "select 1")
+	if source != SourcePrimary || d < 5*time.Millisecond || qerr != nil {
+		t.Fatalf("got %s %s %v", source, d, qerr)
+	}
+
+	// failover; the duration includes the primary attempt
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	if source != SourceSecondary || d < 5*time.Millisecond || qerr != nil {
+		t.Fatalf("got %s %s %v", source, d, qerr)
+	}
+
+	// within the failover window
+	queryValue(t, db, "select 1")
+	if source != SourceSecondary || d >= 5*time.Millisecond {
+		t.Fatalf("got %s %s", source, d)
+	}
+}
+
+func TestPingSecondary(t *testing.T) {
+	p := newFakeServer(t, "primary")
+	s := newFakeServer(t, "secondary")
+	s.setErr(errTest)
+
+	if _, err := Open(fakeDriverName, p.name, fakeDriverName, s.name, WithPingSecondary(true)); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+
+	db, err := Open(fakeDriverName, p.name, fakeDriverName, s.name, WithPingSecondary(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.HasSecondary() {
+		t.Fatal("expected secondary to be dropped")
+	}
+
+	s.setErr(nil)
+	db, err = Open(fakeDriverName, p.name, fakeDriverName, s.name, WithPingSecondary(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !db.HasSecondary() {
+		t.Fatal("expected secondary")
+	}
+}
+
+// customRetryable is a Retryable that is not a *sql.DB
+type customRetryable struct {
+	*sql.DB
+}
+
+func TestUnderlyingDB(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	if p, ok := db.PrimaryDB(); !ok || p != db.Primary {
+		t.Fatal("expected primary *sql.DB")
+	}
+	if s, ok := db.SecondaryDB(); !ok || s != db.Secondary {
+		t.Fatal("expected secondary *sql.DB")
+	}
+
+	custom := newRetryDB(customRetryable{db.Primary.(*sql.DB)}, nil)
+	if p, ok := custom.PrimaryDB(); ok || p != nil {
+		t.Fatal("expected no primary *sql.DB")
+	}
+	if s, ok := custom.SecondaryDB(); ok || s != nil {
+		t.Fatal("expected no secondary *sql.DB")
+	}
+}
+
+func TestRecoverThreshold(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetRetryStrategy(func(uint32) time.Duration { return 0 })
+	db.SetRecoverThreshold(3)
+	served := func(expected ...*fakeServer) {
+		t.Helper()
+		for i, server := range expected {
+			if v := queryValue(t, db, "select 1"); v != server.name {
+				t.Fatalf("query %d: got %q expected %q", i, v, server.name)
+			}
+		}
+	}
+
+	p.setErr(errTest)
+	served(s)
+	// probes of the primary alternate with queries served by the secondary
+	p.setErr(nil)
+	served(p, s)
+	p.setErr(errTest)
+	served(s)
+	if n := db.Stats().RetryCount; n != 2 {
+		t.Fatalf("got retry count %d expected 2", n)
+	}
+
+	p.setErr(nil)
+	served(p, s, p, s)
+	if n := db.Stats().RetryCount; n != 2 {
+		t.Fatalf("got retry count %d expected 2 before the threshold", n)
+	}
+	served(p, p, p)
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+}
+
+func TestSlowQueryAction(t *testing.T) {
+	for _, tc := range []struct {
+		action   SlowQueryAction
+		expected string
+	}{
+		{ReturnResult, "primary"},
+		{RetrySecondary, "secondary"},
+	} {
+		db, p, s := newTestDB(t)
+		db.SetMaxQueryTime(time.Millisecond)
+		db.SetSlowQueryAction(tc.action)
+		p.setDelay(5 * time.Millisecond)
+		expected := map[string]string{"primary": p.name, "secondary": s.name}[tc.expected]
+		if v := queryValue(t, db, "select 1"); v != expected {
+			t.Fatalf("action %d got %q expected %q", tc.action, v, expected)
+		}
+		if n := db.Stats().RetryCount; n != 1 {
+			t.Fatalf("action %d got retry count %d expected 1", tc.action, n)
+		}
+	}
+}
+
+func rawRowsHandler(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+	return []string{"a", "b", "c"}, [][]driver.Value{{[]byte("alpha"), nil, []byte("gamma")}}, nil
+}
+
+func TestScanRaw(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	p.setHandler(rawRowsHandler)
+	var a, b, c []byte
+	buf, err := db.QueryRow("select a, b, c").ScanRaw(make([]byte, 0, 2), &a, &b, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != "alpha" || b != nil || string(c) != "gamma" || string(buf) != "alphagamma" {
+		t.Fatalf("got %q %q %q buf %q", a, b, c, buf)
+	}
+
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"a"}, nil, nil
+	})
+	if _, err := db.QueryRow("select a").ScanRaw(buf, &a); err != sql.ErrNoRows {
+		t.Fatalf("got %v expected %v", err, sql.ErrNoRows)
+	}
+}
+
+// benchRowsHandler returns a row of four 1KB columns
+func benchRowsHandler(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+	v := bytes.Repeat([]byte("x"), 1024)
+	return []string{"a", "b", "c", "d"}, [][]driver.Value{{v, v, v, v}}, nil
+}
+
+func BenchmarkRowScan(b *testing.B) {
+	db, p, _ := newTestDB(b)
+	p.setHandler(benchRowsHandler)
+	var w, x, y, z []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := db.QueryRow("select a, b, c, d").Scan(&w, &x, &y, &z); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRowScanRaw(b *testing.B) {
+	db, p, _ := newTestDB(b)
+	p.setHandler(benchRowsHandler)
+	var w, x, y, z []byte
+	var buf []byte
+	var err error
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if buf, err = db.QueryRow("select a, b, c, d").ScanRaw(buf, &w, &x, &y, &z); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestQueryTimeLimit(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	db.SetMaxQueryTime(time.Second)
+	start := time.Now()
+	if d := db.queryTimeLimit(context.Background(), start); d != time.Second {
+		t.Fatalf("got %s expected 1s", d)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(100*time.Millisecond))
+	defer cancel()
+	if d := db.queryTimeLimit(ctx, start); d != 100*time.Millisecond {
+		t.Fatalf("got %s expected 100ms", d)
+	}
+	ctx, cancel = context.WithDeadline(context.Background(), start.Add(time.Minute))
+	defer cancel()
+	if d := db.queryTimeLimit(ctx, start); d != time.Second {
+		t.Fatalf("got %s expected 1s", d)
+	}
+}
+
+// deadlineCtx reports a deadline without enforcing it, so a query can finish
+// after it instead of being cancelled by database/sql
+type deadlineCtx struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c deadlineCtx) Deadline() (time.Time, bool) { return c.deadline, true }
+
+func TestQueryContextDeadlineFailover(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetMaxQueryTime(time.Second)
+	p.setDelay(20 * time.Millisecond)
+
+	// within the max query time without a deadline
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+
+	// but slow for a caller with a 10ms deadline
+	ctx := deadlineCtx{context.Background(), time.Now().Add(10 * time.Millisecond)}
+	rows, err := db.QueryContext(ctx, "select 1")
+	if v := scanValue(t, rows, err); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if n := db.Stats().RetryCount; n != 1 {
+		t.Fatalf("got retry count %d expected 1", n)
+	}
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	if err := db.Validate(); err != nil {
+		t.Fatalf("got %v for valid config", err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(db *RetryDB)
+	}{
+		{"idle>open", func(db *RetryDB) { db.SetMaxOpenConns(2); db.SetMaxIdleConns(5) }},
+		{"zero max query time", func(db *RetryDB) { db.SetMaxQueryTime(0) }},
+		{"nil strategy", func(db *RetryDB) { db.SetRetryStrategy(nil) }},
+		{"nil primary", func(db *RetryDB) { db.Primary = nil }},
+	}
+	for _, tc := range tests {
+		db := newRetryDB(db.Primary, db.Secondary)
+		tc.setup(db)
+		if err := db.Validate(); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+func TestSoftQueryTime(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	var buf bytes.Buffer
+	db.SetLogger(log.New(&buf, "", 0))
+	db.SetSoftQueryTime(2 * time.Millisecond)
+	db.SetMaxQueryTime(50 * time.Millisecond)
+	p.setDelay(5 * time.Millisecond)
+
+	// only the soft threshold
+	queryValue(t, db, "select 1")
+	if !strings.Contains(buf.String(), "query exceeded soft limit") {
+		t.Fatalf("got log %q", buf.String())
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+
+	// both thresholds
+	buf.Reset()
+	p.setDelay(60 * time.Millisecond)
+	queryValue(t, db, "select 1")
+	if strings.Contains(buf.String(), "soft limit") || !strings.Contains(buf.String(), "query exceeded allowed limit") {
+		t.Fatalf("got log %q", buf.String())
+	}
+	if n := db.Stats().RetryCount; n != 1 {
+		t.Fatalf("got retry count %d expected 1", n)
+	}
+
+}
+
+// closeErrRetryable is a Retryable that returns err from Close
+type closeErrRetryable struct {
+	Retryable
+	err    error
+	closes int
+}
+
+func (c *closeErrRetryable) Close() error {
+	c.closes++
+	c.Retryable.Close()
+	return c.err
+}
+
+func TestClose(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	perr, serr := errors.New("primary close"), errors.New("secondary close")
+	p := &closeErrRetryable{Retryable: db.Primary, err: perr}
+	s := &closeErrRetryable{Retryable: db.Secondary, err: serr}
+	db = OpenWithRetryable(p, s)
+
+	err := db.Close()
+	if !errors.Is(err, perr) || !errors.Is(err, serr) {
+		t.Fatalf("got %v expected both close errors", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("second close got %v", err)
+	}
+	if p.closes != 1 || s.closes != 1 {
+		t.Fatalf("got %d primary and %d secondary closes", p.closes, s.closes)
+	}
+}
+
+func TestReconnectPrimary(t *testing.T) {
+	db, p, s := newTestDB(t)
+	ctx := context.Background()
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+
+	if err := db.ReconnectPrimary(ctx); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+
+	p.setErr(nil)
+	if err := db.ReconnectPrimary(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+}
+
+func TestQueryRowServedBy(t *testing.T) {
+	db, p, s := newTestDB(t)
+	for _, tc := range []struct {
+		name   string
+		err    error
+		source Source
+		value  string
+	}{
+		{"normal", nil, SourcePrimary, p.name},
+		{"failover", errTest, SourceSecondary, s.name},
+		{"secondary window", nil, SourceSecondary, s.name},
+	} {
+		p.setErr(tc.err)
+		row, source := db.QueryRowServedBy("select 1")
+		var v string
+		if err := row.Scan(&v); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if source != tc.source || v != tc.value {
+			t.Fatalf("%s: got %s %q expected %s %q", tc.name, source, v, tc.source, tc.value)
+		}
+	}
+}
+
+func TestOnProbe(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	db.SetRetryStrategy(ImmediateRetry)
+	type probe struct {
+		success bool
+		err     error
+	}
+	var probes []probe
+	db.SetOnProbe(func(success bool, err error) {
+		probes = append(probes, probe{success, err})
+	})
+
+	queryValue(t, db, "select 1")
+	if len(probes) != 0 {
+		t.Fatalf("got %d probes without a failover", len(probes))
+	}
+
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	if len(probes) != 0 {
+		t.Fatalf("got %d probes for the initial failure", len(probes))
+	}
+	// failing probe
+	queryValue(t, db, "select 1")
+	if len(probes) != 1 || probes[0].success || probes[0].err != errTest {
+		t.Fatalf("got %+v", probes)
+	}
+	// successful probe
+	p.setErr(nil)
+	queryValue(t, db, "select 1")
+	if len(probes) != 2 || !probes[1].success || probes[1].err != nil {
+		t.Fatalf("got %+v", probes)
+	}
+	queryValue(t, db, "select 1")
+	if len(probes) != 2 {
+		t.Fatalf("got %d probes after recovery", len(probes))
+	}
+}
+
+func TestNextRetryInterval(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	db.SetRetryStrategy(ExponentialRetryStrategy)
+	p.setErr(errTest)
+	for i := 0; i < 3; i++ {
+		next := db.NextRetryInterval()
+		if db.NextRetryInterval() != next {
+			t.Fatal("NextRetryInterval changed state")
+		}
+		start := time.Now()
+		db.updateRetry(context.Background(), ReasonQueryError, errTest)
+		db.RLock()
+		applied := db.retryUntil.Sub(start)
+		db.RUnlock()
+		if applied < next || applied > next+100*time.Millisecond {
+			t.Fatalf("got %s applied expected %s", applied, next)
+		}
+	}
+}
+
+func TestWithMaxQueryTime(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	db.SetMaxQueryTime(time.Millisecond)
+	p.setDelay(5 * time.Millisecond)
+
+	err := db.WithMaxQueryTime(time.Second, func() error {
+		queryValue(t, db, "select 1")
+		return errTest
+	})
+	if err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+	if db.maxQueryTime != time.Millisecond {
+		t.Fatalf("got max query time %s", db.maxQueryTime)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("got panic %v", r)
+			}
+		}()
+		db.WithMaxQueryTime(time.Second, func() error { panic("boom") })
+	}()
+	if db.maxQueryTime != time.Millisecond {
+		t.Fatalf("got max query time %s after panic", db.maxQueryTime)
+	}
+}
+
+func TestPoolSettersNonSQLDB(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	primary, secondary := db.Primary.(*sql.DB), db.Secondary.(*sql.DB)
+	var nilDB *sql.DB
+	for _, tc := range []struct {
+		name               string
+		primary, secondary Retryable
+	}{
+		{"custom", &closeErrRetryable{Retryable: primary}, &closeErrRetryable{Retryable: secondary}},
+		{"nil secondary", &closeErrRetryable{Retryable: primary}, nil},
+		{"typed nil secondary", &closeErrRetryable{Retryable: primary}, nilDB},
+	} {
+		r := newRetryDB(tc.primary, tc.secondary)
+		r.SetMaxOpenConns(5)
+		r.SetMaxIdleConns(2)
+		r.SetConnMaxLifetime(time.Minute)
+		st := r.Stats()
+		if st.Primary != nil || st.Secondary != nil {
+			t.Fatalf("%s: got endpoint stats %v %v", tc.name, st.Primary, st.Secondary)
+		}
+		if n := primary.Stats().MaxOpenConnections; n != 0 {
+			t.Fatalf("%s: got max open conns %d on wrapped primary", tc.name, n)
+		}
+	}
+
+	db.SetMaxOpenConns(5)
+	db.SetConnMaxLifetime(time.Minute)
+	if n := secondary.Stats().MaxOpenConnections; n != 5 {
+		t.Fatalf("got max open conns %d expected 5", n)
+	}
+}
+
+func TestSlowQueryEscalates(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetRetryStrategy(func(n uint32) time.Duration { return time.Duration(n+1) * time.Minute })
+	db.SetMaxQueryTime(time.Millisecond)
+	db.SetSlowQueryEscalates(false)
+	events := db.FailoverEvents()
+	p.setDelay(5 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		queryValue(t, db, "select 1")
+		state := db.exportState()
+		if state.retryCount != 0 {
+			t.Fatalf("got retry count %d expected 0 after %d slow queries", state.retryCount, i+1)
+		}
+		if d := time.Until(state.retryUntil); d < 50*time.Second || d > time.Minute {
+			t.Fatalf("got failover window %s", d)
+		}
+		if v := queryValue(t, db, "select 1"); v != s.name {
+			t.Fatalf("got %q expected %q", v, s.name)
+		}
+		state.retryUntil = time.Now().Add(-time.Second)
+		db.importState(state)
+	}
+	// slow probes extend the same outage
+	if n := db.Stats().TotalFailovers; n != 1 {
+		t.Fatalf("got %d failovers expected 1", n)
+	}
+	if n := db.Stats().RetryCount; n != 3 {
+		t.Fatalf("got retry count %d expected 3", n)
+	}
+	if n := len(events); n != 1 {
+		t.Fatalf("got %d failover events expected 1", n)
+	}
+
+	p.setDelay(0)
+	if err := db.ReconnectPrimary(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q after ReconnectPrimary", v, p.name)
+	}
+
+	// query errors still escalate
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	if state := db.exportState(); state.retryCount != 1 {
+		t.Fatalf("got retry count %d expected 1", state.retryCount)
+	}
+}
+
+func TestNamedArgsFailover(t *testing.T) {
+	db, p, s := newTestDB(t)
+	var got [][]driver.NamedValue
+	record := func(err error) fakeHandler {
+		return func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+			p.Lock()
+			got = append(got, args)
+			p.Unlock()
+			return []string{"id"}, [][]driver.Value{{int64(1)}}, err
+		}
+	}
+	p.setHandler(record(errTest))
+	s.setHandler(record(nil))
+	want := []driver.NamedValue{
+		{Name: "id", Ordinal: 1, Value: int64(7)},
+		{Name: "name", Ordinal: 2, Value: "x"},
+	}
+
+	rows, err := db.Query("select * from users where id = @id and name = @name", sql.Named("id", 7), sql.Named("name", "x"))
+	scanValue(t, rows, err)
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	var id int
+	if err := db.QueryRow("select id from users where id = @id and name = @name", sql.Named("id", 7), sql.Named("name", "x")).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d queries expected 4", len(got))
+	}
+	for i, args := range got {
+		if !reflect.DeepEqual(args, want) {
+			t.Fatalf("query %d got args %+v expected %+v", i, args, want)
+		}
+	}
+}
+
+func TestOnRetry(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetConnRetries(2, 0)
+	type retry struct {
+		attempt int
+		err     error
+		source  Source
+	}
+	var retries []retry
+	db.SetOnRetry(func(query string, attempt int, err error, source Source) {
+		if query != "select 1" {
+			t.Errorf("got query %q", query)
+		}
+		retries = append(retries, retry{attempt, err, source})
+	})
+
+	p.setErr(syscall.ECONNRESET)
+	s.setErr(errTest)
+	db.Query("select 1")
+	want := []retry{
+		{1, syscall.ECONNRESET, SourcePrimary},
+		{2, syscall.ECONNRESET, SourcePrimary},
+	}
+	if !reflect.DeepEqual(retries, want) {
+		t.Fatalf("got %+v expected %+v", retries, want)
+	}
+
+	retries = nil
+	s.setErr(syscall.ECONNRESET)
+	db.Query("select 1")
+	want = []retry{
+		{1, syscall.ECONNRESET, SourceSecondary},
+		{2, syscall.ECONNRESET, SourceSecondary},
+	}
+	if !reflect.DeepEqual(retries, want) {
+		t.Fatalf("got %+v expected %+v", retries, want)
+	}
+}
@@ -0,0 +1,184 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// closeCtx is the context of a single endpoint query, used to run funcs once
+// the Rows it returns are closed. database/sql ties Rows to their query
+// context with a context derived from it that is cancelled when the Rows are
+// closed. Since closeCtx implements AfterFunc the context package registers
+// that derived context through it, and unregistering it on Close is the
+// signal to run the funcs. This relies on database/sql internals so
+// TestClosingQuery pins it.
+type closeCtx struct {
+	context.Context
+	done     chan struct{}
+	stopDone func() bool
+	sync.Mutex
+	registered  int  // AfterFunc registrations
+	lastStopped bool // the latest registration was already unregistered
+	rows        int  // the registration tying the returned Rows to c
+	closed      bool
+	funcs       []func()
+}
+
+func newCloseCtx(ctx context.Context) *closeCtx {
+	c := &closeCtx{Context: ctx, done: make(chan struct{})}
+	if ctx.Err() != nil {
+		// AfterFunc would close done asynchronously, letting database/sql
+		// start a query on a context that is already done
+		close(c.done)
+		c.stopDone = func() bool { return false }
+		return c
+	}
+	c.stopDone = context.AfterFunc(ctx, func() {
+		close(c.done)
+		// the Rows are closed by database/sql once ctx is done
+		c.Lock()
+		watching := c.rows > 0
+		c.Unlock()
+		if watching {
+			c.close()
+		}
+	})
+	return c
+}
+
+// Done is distinct from the parent's so contexts derived from c register
+// through AfterFunc instead of directly with the parent
+func (c *closeCtx) Done() <-chan struct{} { return c.done }
+
+// Err is the parent's, without waiting for Done to be closed, since funcs
+// registered with AfterFunc may run first and expect an error
+func (c *closeCtx) Err() error { return c.Context.Err() }
+
+// AfterFunc is called by the context package for each context derived from c
+func (c *closeCtx) AfterFunc(f func()) func() bool {
+	c.Lock()
+	c.registered++
+	c.lastStopped = false
+	id := c.registered
+	c.Unlock()
+	stop := context.AfterFunc(c.Context, f)
+	return func() bool {
+		stopped := stop()
+		c.Lock()
+		closed := id == c.rows
+		if id == c.registered {
+			c.lastStopped = true
+		}
+		c.Unlock()
+		if closed {
+			c.close()
+		}
+		return stopped
+	}
+}
+
+// watch arranges for the funcs passed to onClose to run once rows are closed.
+// They run now if the query failed or rows are not tied to c, such as Rows
+// from a Retryable without QueryContext.
+func (c *closeCtx) watch(rows *sql.Rows, err error) {
+	c.Lock()
+	if rows != nil && err == nil && c.registered > 0 && !c.lastStopped {
+		c.rows = c.registered
+	}
+	watching := c.rows > 0
+	c.Unlock()
+	if !watching || c.Context.Err() != nil {
+		c.close()
+	}
+}
+
+// onClose runs f once the Rows are closed, or now if they already are
+func (c *closeCtx) onClose(f func()) {
+	c.Lock()
+	if !c.closed {
+		c.funcs = append(c.funcs, f)
+		c.Unlock()
+		return
+	}
+	c.Unlock()
+	f()
+}
+
+// close runs the onClose funcs once. It may be called while database/sql
+// holds the Rows lock so the funcs must not use the Rows.
+func (c *closeCtx) close() {
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return
+	}
+	c.closed = true
+	funcs := c.funcs
+	c.funcs = nil
+	c.Unlock()
+	c.stopDone()
+	for _, f := range funcs {
+		f()
+	}
+}
+
+// rowsClosers tracks the endpoint queries of a RetryDB query so funcs can run
+// once the Rows it returns are closed; see withRowsClosers
+type rowsClosers struct {
+	sync.Mutex
+	m map[*sql.Rows]*closeCtx
+}
+
+// withRowsClosers returns ctx tracking the Rows of endpoint queries made with
+// it, reusing any rowsClosers ctx already has
+func withRowsClosers(ctx context.Context) (context.Context, *rowsClosers) {
+	if r, ok := ctx.Value(rowsClosersKey).(*rowsClosers); ok {
+		return ctx, r
+	}
+	r := &rowsClosers{}
+	return context.WithValue(ctx, rowsClosersKey, r), r
+}
+
+// onClose runs f once rows, from an endpoint query tracked by r, are closed.
+// f runs now when rows is nil or was not tracked.
+func (r *rowsClosers) onClose(rows *sql.Rows, f func()) {
+	r.Lock()
+	c, ok := r.m[rows]
+	r.Unlock()
+	if rows == nil || !ok {
+		f()
+		return
+	}
+	c.onClose(f)
+}
+
+// closingQuery runs an endpoint query with run, calling release once the
+// returned Rows are closed (or now if the query fails). release may be nil
+// when only a rowsClosers in ctx needs to track the Rows.
+func closingQuery(ctx context.Context, release func(), run func(context.Context) (*sql.Rows, error)) (*sql.Rows, error) {
+	r, tracked := ctx.Value(rowsClosersKey).(*rowsClosers)
+	if !tracked && release == nil {
+		return run(ctx)
+	}
+	c := newCloseCtx(ctx)
+	if release != nil {
+		c.onClose(release)
+	}
+	rows, err := run(c)
+	if tracked && rows != nil {
+		r.Lock()
+		if r.m == nil {
+			r.m = make(map[*sql.Rows]*closeCtx)
+		}
+		r.m[rows] = c
+		r.Unlock()
+		c.onClose(func() {
+			r.Lock()
+			delete(r.m, rows)
+			r.Unlock()
+		})
+	}
+	c.watch(rows, err)
+	return rows, err
+}
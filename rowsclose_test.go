@@ -0,0 +1,62 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// TestClosingQuery pins the database/sql and context behavior closeCtx relies
+// on: Rows from QueryContext register a context derived from the query
+// context through AfterFunc and unregister it when closed.
+func TestClosingQuery(t *testing.T) {
+	s := newFakeServer(t, "server")
+	sqldb, err := sql.Open(fakeDriverName, s.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqldb.Close()
+	var released atomic.Int32
+	query := func(ctx context.Context) (*sql.Rows, error) {
+		return closingQuery(ctx, func() { released.Add(1) }, func(ctx context.Context) (*sql.Rows, error) {
+			return sqldb.QueryContext(ctx, "select 1")
+		})
+	}
+
+	rows, err := query(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := released.Load(); n != 0 {
+		t.Fatal("released before the Rows were closed; database/sql no longer registers the Rows context through AfterFunc so closeCtx needs replacing")
+	}
+	rows.Close()
+	if n := released.Load(); n != 1 {
+		t.Fatalf("released %d times when the Rows were closed expected 1; database/sql no longer unregisters the Rows context so closeCtx needs replacing", n)
+	}
+
+	// cancelling the query context closes the Rows
+	ctx, cancel := context.WithCancel(context.Background())
+	rows, err = query(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for rows.Next() {
+	}
+	if n := released.Load(); n != 2 {
+		t.Fatalf("released %d times after cancel expected 2", n)
+	}
+	rows.Close()
+
+	// a failed query releases immediately
+	s.setErr(errors.New("query failed"))
+	if _, err := query(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := released.Load(); n != 3 {
+		t.Fatalf("released %d times after an error expected 3", n)
+	}
+}
@@ -0,0 +1,134 @@
+package retrydb
+
+import (
+	"context"
+	"math/rand"
+)
+
+// SetSecondaries replaces the Secondary with a set of read replicas. Secondary
+// queries are spread across all of them (see SetSecondaryWeights) while
+// Secondary is set to the first and is used for connections, pings and
+// stats. Previous secondaries that are not in secondaries are closed. Close
+// closes every secondary.
+func (db *RetryDB) SetSecondaries(secondaries ...Retryable) {
+	db.Lock()
+	defer db.Unlock()
+	previous := db.secondaries
+	if db.Secondary != nil && !containsSecondary(previous, db.Secondary) {
+		previous = append(previous, db.Secondary)
+	}
+	for _, s := range previous {
+		if containsSecondary(secondaries, s) {
+			continue
+		}
+		if err := s.Close(); err != nil {
+			db.logger(context.Background()).Printf("failed closing replaced secondary. %s", err)
+		}
+	}
+	db.secondaries = secondaries
+	db.secondaryWeights = nil
+	db.secondaryWeightTotal = 0
+	if len(secondaries) > 0 {
+		db.Secondary = secondaries[0]
+	} else {
+		db.Secondary = nil
+	}
+}
+
+// SetSecondaryWeights sets the relative weight of each secondary configured
+// with SetSecondaries; a secondary with weight 2 serves twice as many queries
+// as one with weight 1. A weight of zero removes a secondary from rotation. If
+// every weight is zero the first secondary is used. nil restores even
+// weighting. The number of weights must match the number of secondaries; see
+// Validate.
+func (db *RetryDB) SetSecondaryWeights(weights []int) {
+	var total int
+	if weights != nil {
+		weights = append([]int(nil), weights...)
+	}
+	for i, w := range weights {
+		if w < 0 {
+			weights[i] = 0
+			continue
+		}
+		total += w
+	}
+	db.Lock()
+	db.secondaryWeights = weights
+	db.secondaryWeightTotal = total
+	db.Unlock()
+}
+
+// SetSecondaryEnabled stops (false) or resumes (true) using the Secondary
+// without closing its pool. While disabled queries behave as if there were no
+// Secondary: they go to the Primary and its errors are returned without
+// failover.
+func (db *RetryDB) SetSecondaryEnabled(enabled bool) {
+	db.Lock()
+	db.secondaryDisabled = !enabled
+	db.Unlock()
+}
+
+// secondaryActive reports whether there is a Secondary that has not been
+// disabled with SetSecondaryEnabled
+func (db *RetryDB) secondaryActive() bool {
+	if db.Secondary == nil {
+		return false
+	}
+	db.RLock()
+	defer db.RUnlock()
+	return !db.secondaryDisabled
+}
+
+// pickSecondary returns the secondary to query using weighted random selection
+func (db *RetryDB) pickSecondary() Retryable {
+	db.RLock()
+	defer db.RUnlock()
+	if len(db.secondaries) < 2 {
+		return db.Secondary
+	}
+	if db.secondaryWeights == nil {
+		return db.secondaries[rand.Intn(len(db.secondaries))]
+	}
+	if db.secondaryWeightTotal == 0 || len(db.secondaryWeights) != len(db.secondaries) {
+		return db.Secondary
+	}
+	n := rand.Intn(db.secondaryWeightTotal)
+	for i, w := range db.secondaryWeights {
+		if n < w {
+			return db.secondaries[i]
+		}
+		n -= w
+	}
+	return db.Secondary
+}
+
+// pickedSecondary is the secondary picked for a query by withSecondary
+type pickedSecondary struct {
+	db        *RetryDB
+	secondary Retryable
+}
+
+// withSecondary returns ctx recording the secondary that serves the query,
+// picking one with pickSecondary unless ctx already has one, so replica checks
+// run against the same secondary as the query itself
+func (db *RetryDB) withSecondary(ctx context.Context) (context.Context, Retryable) {
+	// a RetryDB nested as a secondary picks its own
+	if p, ok := ctx.Value(secondaryKey).(pickedSecondary); ok && p.db == db {
+		return ctx, p.secondary
+	}
+	s := db.sessionSecondary(ctx)
+	if s == nil {
+		s = db.pickSecondary()
+	}
+	return context.WithValue(ctx, secondaryKey, pickedSecondary{db, s}), s
+}
+
+func containsSecondary(secondaries []Retryable, s Retryable) bool {
+	for _, candidate := range secondaries {
+		if candidate == s {
+			return true
+		}
+	}
+	return false
+}
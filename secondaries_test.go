@@ -0,0 +1,105 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestSecondaryWeights(t *testing.T) {
+	db, _, s1 := newTestDB(t)
+	s2 := newFakeServer(t, "secondary2")
+	s3 := newFakeServer(t, "secondary3")
+	var secondaries []Retryable
+	for _, s := range []*fakeServer{s2, s3} {
+		sdb, err := sql.Open(fakeDriverName, s.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secondaries = append(secondaries, sdb)
+	}
+	db.SetSecondaries(append([]Retryable{db.Secondary}, secondaries...)...)
+	db.SetSecondaryWeights([]int{1, 3, 0})
+	if err := db.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 2000
+	for i := 0; i < n; i++ {
+		if _, err := readAll(db.secondaryQuery(context.Background(), "select 1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c := s3.queryCount(); c != 0 {
+		t.Fatalf("zero weight secondary served %d queries", c)
+	}
+	// expect 25% / 75% with some slack
+	if c := s1.queryCount(); c < n/5 || c > n*3/10 {
+		t.Fatalf("secondary1 served %d of %d queries", c, n)
+	}
+	if c := s2.queryCount(); c < n*7/10 || c > n*4/5 {
+		t.Fatalf("secondary2 served %d of %d queries", c, n)
+	}
+
+	db.SetSecondaryWeights([]int{1})
+	if err := db.Validate(); err == nil {
+		t.Fatal("expected weight count mismatch error")
+	}
+}
+
+func TestSecondaryChecksPickedReplica(t *testing.T) {
+	db, p, s1 := newTestDB(t)
+	s2 := newFakeServer(t, "secondary2")
+	secondary2, err := sql.Open(fakeDriverName, s2.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db.Secondary
+	db.SetSecondaries(secondary2)
+	if err := old.Ping(); err == nil {
+		t.Fatal("expected the replaced secondary to be closed")
+	}
+	secondary1, err := sql.Open(fakeDriverName, s1.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSecondaries(secondary1, secondary2)
+	db.SetSecondaryWeights([]int{0, 1})
+
+	var checked []Retryable
+	db.SetMaxReplicaLag(time.Minute, func(ctx context.Context, r Retryable) (time.Duration, error) {
+		checked = append(checked, r)
+		return 0, nil
+	})
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s2.name {
+		t.Fatalf("got %q expected %q", v, s2.name)
+	}
+	if len(checked) != 1 || checked[0] != secondary2 {
+		t.Fatalf("lag checked on %v expected secondary2", checked)
+	}
+}
+
+func TestSetSecondaryEnabled(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetSecondaryEnabled(false)
+	p.setErr(errTest)
+	for i := 0; i < 2; i++ {
+		if _, err := db.Query("select 1"); err != errTest {
+			t.Fatalf("got %v expected %v", err, errTest)
+		}
+	}
+	if n := s.queryCount(); n != 0 {
+		t.Fatalf("got %d secondary queries expected 0", n)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+
+	// the pool stays open and failover resumes once re-enabled
+	db.SetSecondaryEnabled(true)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+}
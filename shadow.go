@@ -0,0 +1,138 @@
+package retrydb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"strconv"
+)
+
+// maxShadowRows bounds the memory used by a shadow read; larger result sets
+// are not compared
+const maxShadowRows = 10000
+
+// maxShadowReads bounds the shadow reads in flight; queries sampled while it
+// is reached are not shadowed
+const maxShadowReads = 8
+
+var errTooManyRows = errors.New("retrydb: too many rows")
+
+type shadowRead struct {
+	sampleRate float64
+	onMismatch func(query string, primary, secondary [][]byte)
+	inflight   chan struct{}
+}
+
+// SetShadowRead runs a sampleRate fraction (0 to 1) of successful Primary
+// queries against both the Primary and the Secondary in the background and
+// calls onMismatch when the result sets differ. Each result row is encoded as
+// its quoted column values separated by spaces, with NULL for NULL values.
+// Result sets of more than 10000 rows are not compared. At most 8 shadow reads
+// run at once, each bounded by the max query time; samples beyond that are
+// dropped. Shadow reads never affect the rows returned to the caller and are
+// cancelled by Shutdown. A sampleRate of 0 disables shadow reads.
+func (db *RetryDB) SetShadowRead(sampleRate float64, onMismatch func(query string, primary, secondary [][]byte)) {
+	db.Lock()
+	if sampleRate <= 0 || onMismatch == nil {
+		db.shadow = nil
+	} else {
+		db.shadow = &shadowRead{sampleRate, onMismatch, make(chan struct{}, maxShadowReads)}
+	}
+	db.Unlock()
+}
+
+// maybeShadow samples a successful Primary query for a shadow read
+func (db *RetryDB) maybeShadow(query string, args []interface{}) {
+	db.RLock()
+	shadow, timeout := db.shadow, db.maxQueryTime
+	db.RUnlock()
+	if shadow == nil || !db.secondaryActive() || rand.Float64() >= shadow.sampleRate {
+		return
+	}
+	select {
+	case shadow.inflight <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-shadow.inflight }()
+		ctx, cancel := context.WithTimeout(db.ctx, timeout)
+		defer cancel()
+		read := func(endpoint Retryable) ([][]byte, error) {
+			rows, err := queryContext(ctx, endpoint, query, args...)
+			if err != nil {
+				return nil, err
+			}
+			return readRows(rows, maxShadowRows)
+		}
+		primary, err := read(db.Primary)
+		if err != nil {
+			return
+		}
+		secondary, err := read(db.pickSecondary())
+		if err != nil {
+			return
+		}
+		if !equalRows(primary, secondary) {
+			db.callback(ctx, "shadow read mismatch", func() { shadow.onMismatch(query, primary, secondary) })
+		}
+	}()
+}
+
+// readAll reads and closes rows, encoding each row as a single []byte
+func readAll(rows *sql.Rows, err error) ([][]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return readRows(rows, 0)
+}
+
+// readRows is readAll failing with errTooManyRows past maxRows when positive
+func readRows(rows *sql.Rows, maxRows int) ([][]byte, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var result [][]byte
+	for rows.Next() {
+		if maxRows > 0 && len(result) == maxRows {
+			return nil, errTooManyRows
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		var row []byte
+		for i, v := range values {
+			if i > 0 {
+				row = append(row, ' ')
+			}
+			if v == nil {
+				row = append(row, "NULL"...)
+			} else {
+				row = strconv.AppendQuote(row, string(v))
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func equalRows(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestShadowRead(t *testing.T) {
+	db, p, s := newTestDB(t)
+	type mismatch struct {
+		query              string
+		primary, secondary [][]byte
+	}
+	mismatches := make(chan mismatch, 1)
+	db.SetShadowRead(1, func(query string, primary, secondary [][]byte) {
+		mismatches <- mismatch{query, primary, secondary}
+	})
+
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	select {
+	case m := <-mismatches:
+		if m.query != "select 1" || len(m.primary) != 1 || len(m.secondary) != 1 {
+			t.Fatalf("unexpected mismatch %#v", m)
+		}
+		if got, want := string(m.secondary[0]), `"`+s.name+`"`; got != want {
+			t.Fatalf("got %s expected %s", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for mismatch")
+	}
+
+	// matching results are not reported
+	s.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"source"}, [][]driver.Value{{p.name}}, nil
+	})
+	queryValue(t, db, "select 2")
+	for s.queryCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case m := <-mismatches:
+		t.Fatalf("unexpected mismatch %#v", m)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// sampling disabled
+	db.SetShadowRead(0, nil)
+	queryValue(t, db, "select 3")
+	time.Sleep(10 * time.Millisecond)
+	if n := s.queryCount(); n != 2 {
+		t.Fatalf("got %d secondary queries expected 2", n)
+	}
+}
+
+func TestShadowReadMaxRows(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	n := 3
+	p.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		rows := make([][]driver.Value, n)
+		for i := range rows {
+			rows[i] = []driver.Value{int64(i)}
+		}
+		return []string{"n"}, rows, nil
+	})
+	for _, tc := range []struct {
+		maxRows int
+		err     error
+	}{
+		{0, nil},
+		{-1, nil},
+		{3, nil},
+		{2, errTooManyRows},
+	} {
+		rows, err := db.Query("select n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readRows(rows, tc.maxRows)
+		if err != tc.err {
+			t.Fatalf("max rows %d got %v expected %v", tc.maxRows, err, tc.err)
+		}
+		if err == nil && len(got) != 3 {
+			t.Fatalf("max rows %d got %d rows", tc.maxRows, len(got))
+		}
+	}
+
+	// an oversized shadow read is not compared
+	n = maxShadowRows + 1
+	mismatches := make(chan struct{}, 1)
+	db.SetShadowRead(1, func(query string, primary, secondary [][]byte) { mismatches <- struct{}{} })
+	queryValue(t, db, "select n")
+	select {
+	case <-mismatches:
+		t.Fatal("unexpected mismatch")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestShadowReadBounded(t *testing.T) {
+	db, _, s := newTestDB(t)
+	release := make(chan struct{})
+	s.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		return []string{"source"}, [][]driver.Value{{s.name}}, nil
+	})
+	db.SetShadowRead(1, func(query string, primary, secondary [][]byte) {})
+
+	for i := 0; i < maxShadowReads*2; i++ {
+		queryValue(t, db, "select 1")
+	}
+	for s.queryCount() < maxShadowReads {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := s.queryCount(); n != maxShadowReads {
+		t.Fatalf("got %d shadow reads in flight expected %d", n, maxShadowReads)
+	}
+
+	// shadow reads are cancelled by Shutdown
+	db.Shutdown()
+	for i := 0; len(db.shadow.inflight) > 0; i++ {
+		if i == 100 {
+			t.Fatal("shadow reads not cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+}
@@ -0,0 +1,62 @@
+package retrydb
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// retryState is the failover state captured by exportState
+type retryState struct {
+	retryCount       uint32
+	slowRetryCount   uint32
+	retryUntil       time.Time
+	secondaryQueries uint32
+}
+
+// exportState captures the failover state of db
+func (db *RetryDB) exportState() retryState {
+	db.RLock()
+	defer db.RUnlock()
+	return retryState{
+		retryCount:       atomic.LoadUint32(&db.retryCount),
+		slowRetryCount:   atomic.LoadUint32(&db.slowRetryCount),
+		retryUntil:       db.retryUntil,
+		secondaryQueries: atomic.LoadUint32(&db.secondaryQueries),
+	}
+}
+
+// importState puts db into the failover state s without waiting on timing
+func (db *RetryDB) importState(s retryState) {
+	db.Lock()
+	defer db.Unlock()
+	atomic.StoreUint32(&db.retryCount, s.retryCount)
+	atomic.StoreUint32(&db.slowRetryCount, s.slowRetryCount)
+	db.retryUntil = s.retryUntil
+	atomic.StoreUint32(&db.secondaryQueries, s.secondaryQueries)
+}
+
+func TestImportState(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.importState(retryState{retryCount: 3, retryUntil: time.Now().Add(time.Minute)})
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := p.queryCount(); n != 0 {
+		t.Fatalf("got %d primary queries expected 0", n)
+	}
+	state := db.exportState()
+	if state.retryCount != 3 || state.secondaryQueries != 1 {
+		t.Fatalf("got %+v", state)
+	}
+
+	// an expired window probes the primary and recovers
+	state.retryUntil = time.Now().Add(-time.Second)
+	db.importState(state)
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if state := db.exportState(); state.retryCount != 0 || !state.retryUntil.IsZero() {
+		t.Fatalf("got %+v after recovery", state)
+	}
+}
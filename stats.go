@@ -1,7 +1,9 @@
 package retrydb
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"sync/atomic"
 	"time"
 )
@@ -12,31 +14,147 @@ type DBStats struct {
 	Secondary        *sql.DBStats `json:"secondary"`
 	RetryUntil       time.Time    `json:"retry_until"`
 	RetryUntilTs     int64        `json:"retry_until_ts"`
-	RetryCount       uint32       `json:"retry_count"`
+	RetryCount       uint32       `json:"retry_count"` // Primary failures since it was last re-enabled
 	SecondaryQueries uint32       `json:"secondary_queries"`
+	TotalFailovers   uint64       `json:"total_failovers"`
+	DroppedEvents    uint64       `json:"dropped_events"`
+	PrimaryErrors    uint64       `json:"primary_errors"`
+	SecondaryErrors  uint64       `json:"secondary_errors"`
+	PrimaryLatency   LatencyStats `json:"primary_latency"`
+	SecondaryLatency LatencyStats `json:"secondary_latency"`
+	// FailoverReasons counts failovers by FailoverReason
+	FailoverReasons map[string]uint64 `json:"failover_reasons"`
 }
 type hasStats interface {
 	Stats() sql.DBStats
 }
 
-// Stats returns database statistics for the primary and secondary database
+// hasStatsContext is implemented by a Retryable whose stats gathering may
+// block
+type hasStatsContext interface {
+	StatsContext(ctx context.Context) (sql.DBStats, error)
+}
+
+// Stats returns database statistics for the primary and secondary database.
+// Each call returns new Primary, Secondary and FailoverReasons values that
+// later calls never modify.
 func (r *RetryDB) Stats() (d DBStats) {
-	if db, ok := r.Primary.(hasStats); ok && r.Primary != nil {
-		v := db.Stats()
-		d.Primary = &v
+	r.StatsInto(&d)
+	return
+}
+
+// StatsInto fills d with database statistics for the primary and secondary
+// database. The Primary and Secondary pointers and the FailoverReasons map
+// already set on d are reused so repeatedly passing the same DBStats does not
+// allocate. Their previous contents are overwritten, including through any
+// copy of d sharing them; use Stats to keep a snapshot.
+func (r *RetryDB) StatsInto(d *DBStats) {
+	d.Primary = endpointStats(r.Primary, d.Primary)
+	d.Secondary = endpointStats(r.Secondary, d.Secondary)
+	r.retryStatsInto(d)
+}
+
+// StatsContext is Stats for endpoints that may block while gathering stats.
+// Endpoints implementing StatsContext(context.Context) (sql.DBStats, error)
+// are passed ctx; others are read synchronously once ctx is checked.
+func (r *RetryDB) StatsContext(ctx context.Context) (DBStats, error) {
+	var d DBStats
+	var err error
+	if d.Primary, err = endpointStatsContext(ctx, r.Primary); err != nil {
+		return DBStats{}, err
+	}
+	if d.Secondary, err = endpointStatsContext(ctx, r.Secondary); err != nil {
+		return DBStats{}, err
 	}
-	if db, ok := r.Secondary.(hasStats); ok && r.Secondary != nil {
-		v := db.Stats()
-		d.Secondary = &v
+	r.retryStatsInto(&d)
+	return d, nil
+}
+
+// endpointStats returns the stats for endpoint, reusing d when not nil, or
+// nil if endpoint has no stats
+func endpointStats(endpoint Retryable, d *sql.DBStats) *sql.DBStats {
+	db, ok := endpoint.(hasStats)
+	if !ok {
+		return nil
+	}
+	if sdb, ok := endpoint.(*sql.DB); ok && sdb == nil {
+		return nil
+	}
+	if d == nil {
+		d = new(sql.DBStats)
+	}
+	*d = db.Stats()
+	return d
+}
+
+func endpointStatsContext(ctx context.Context, endpoint Retryable) (*sql.DBStats, error) {
+	if db, ok := endpoint.(hasStatsContext); ok {
+		s, err := db.StatsContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &s, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return endpointStats(endpoint, nil), nil
+}
+
+// retryStatsInto fills in the failover state of d
+func (r *RetryDB) retryStatsInto(d *DBStats) {
 	r.RLock()
 	retryUntil := r.retryUntil
 	r.RUnlock()
 	if time.Now().Before(retryUntil) {
 		d.RetryUntil = retryUntil
 		d.RetryUntilTs = retryUntil.Unix()
+	} else {
+		d.RetryUntil = time.Time{}
+		d.RetryUntilTs = 0
 	}
-	d.RetryCount = atomic.LoadUint32(&r.retryCount)
+	d.RetryCount = atomic.LoadUint32(&r.retryCount) + atomic.LoadUint32(&r.slowRetryCount)
 	d.SecondaryQueries = atomic.LoadUint32(&r.secondaryQueries)
-	return
+	d.TotalFailovers = r.totalFailovers.Load()
+	d.DroppedEvents = r.droppedEvents.Load()
+	d.PrimaryErrors = r.primaryErrors.Load()
+	d.SecondaryErrors = r.secondaryErrors.Load()
+	d.PrimaryLatency = r.primaryLatency.stats()
+	d.SecondaryLatency = r.secondaryLatency.stats()
+	if d.FailoverReasons == nil {
+		d.FailoverReasons = make(map[string]uint64, numReasons-1)
+	}
+	for reason := ReasonQueryError; reason < numReasons; reason++ {
+		d.FailoverReasons[reason.String()] = r.failoverReasons[reason].Load()
+	}
+}
+
+// String returns d as a single line of key=value pairs for log based metrics,
+// for example "retry_count=3 secondary_queries=42 retrying=1 primary_open=5
+// secondary_open=2"
+func (d DBStats) String() string {
+	var retrying, primaryOpen, secondaryOpen int
+	if !d.RetryUntil.IsZero() {
+		retrying = 1
+	}
+	if d.Primary != nil {
+		primaryOpen = d.Primary.OpenConnections
+	}
+	if d.Secondary != nil {
+		secondaryOpen = d.Secondary.OpenConnections
+	}
+	return fmt.Sprintf("retry_count=%d secondary_queries=%d retrying=%d primary_open=%d secondary_open=%d",
+		d.RetryCount, d.SecondaryQueries, retrying, primaryOpen, secondaryOpen)
+}
+
+// DebugString returns a one line summary of the current retry state
+func (r *RetryDB) DebugString() string {
+	r.RLock()
+	defer r.RUnlock()
+	return fmt.Sprintf("retrydb{retrying=%t until=%s count=%d secondary_queries=%d has_secondary=%t}",
+		time.Now().Before(r.retryUntil),
+		r.retryUntil.Format(time.RFC3339),
+		atomic.LoadUint32(&r.retryCount)+atomic.LoadUint32(&r.slowRetryCount),
+		atomic.LoadUint32(&r.secondaryQueries),
+		r.Secondary != nil)
 }
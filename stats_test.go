@@ -0,0 +1,168 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTotalFailovers(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		// repeated failures while disabled are a single failover
+		db.updateRetry(ctx, ReasonQueryError, errTest)
+		db.updateRetry(ctx, ReasonQueryError, errTest)
+		db.updateRetry(ctx, ReasonNone, nil)
+	}
+	if n := db.Stats().TotalFailovers; n != 3 {
+		t.Fatalf("got %d failovers expected 3", n)
+	}
+}
+
+func BenchmarkStats(b *testing.B) {
+	db, _, _ := newTestDB(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		db.Stats()
+	}
+}
+
+func BenchmarkStatsInto(b *testing.B) {
+	db, _, _ := newTestDB(b)
+	var d DBStats
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		db.StatsInto(&d)
+	}
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	first := db.Stats()
+	db.updateRetry(context.Background(), ReasonQueryError, errTest)
+	second := db.Stats()
+	if first.Primary == second.Primary || first.FailoverReasons["query_error"] != 0 {
+		t.Fatalf("Stats shares values between calls: %#v", first)
+	}
+
+	// StatsInto reuses the values already set on d
+	var d DBStats
+	db.StatsInto(&d)
+	primary, reasons := d.Primary, d.FailoverReasons
+	db.StatsInto(&d)
+	if d.Primary != primary || reflect.ValueOf(d.FailoverReasons).Pointer() != reflect.ValueOf(reasons).Pointer() {
+		t.Fatal("expected StatsInto to reuse d")
+	}
+}
+
+func TestDebugString(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	db.retryUntil = time.Date(2100, 1, 2, 3, 4, 5, 0, time.UTC)
+	db.retryCount = 3
+	db.secondaryQueries = 42
+	expected := "retrydb{retrying=true until=2100-01-02T03:04:05Z count=3 secondary_queries=42 has_secondary=true}"
+	if s := db.DebugString(); s != expected {
+		t.Fatalf("got %q expected %q", s, expected)
+	}
+}
+
+func TestDBStatsString(t *testing.T) {
+	d := DBStats{
+		Primary:          &sql.DBStats{OpenConnections: 5},
+		Secondary:        &sql.DBStats{OpenConnections: 2},
+		RetryUntil:       time.Now().Add(time.Minute),
+		RetryCount:       3,
+		SecondaryQueries: 42,
+	}
+	if got, want := d.String(), "retry_count=3 secondary_queries=42 retrying=1 primary_open=5 secondary_open=2"; got != want {
+		t.Fatalf("got %q expected %q", got, want)
+	}
+	if got, want := (DBStats{}).String(), "retry_count=0 secondary_queries=0 retrying=0 primary_open=0 secondary_open=0"; got != want {
+		t.Fatalf("got %q expected %q", got, want)
+	}
+}
+
+// blockingStats is a Retryable whose stats gathering blocks until ctx is done
+type blockingStats struct {
+	Retryable
+}
+
+func (blockingStats) StatsContext(ctx context.Context) (sql.DBStats, error) {
+	<-ctx.Done()
+	return sql.DBStats{}, ctx.Err()
+}
+
+func TestStatsContext(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	st, err := db.StatsContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Primary == nil || st.Secondary == nil {
+		t.Fatal("expected primary and secondary stats")
+	}
+
+	db = OpenWithRetryable(blockingStats{db.Primary}, db.Secondary)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := db.StatsContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v expected %v", err, context.DeadlineExceeded)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := OpenWithRetryable(db.Secondary, nil).StatsContext(cancelled); err != context.Canceled {
+		t.Fatalf("got %v expected %v", err, context.Canceled)
+	}
+}
+
+func TestFailoverReasons(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	db.SetMaxQueryTime(20 * time.Millisecond)
+	reopen := func() { db.updateRetry(context.Background(), ReasonNone, nil) }
+
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	queryValue(t, db, "select 1") // within the window; not a new failover
+	reopen()
+	db.Exec("insert into t values (1)")
+	p.setErr(errors.New("Error 1290 (HY000): read-only"))
+	db.Exec("insert into t values (1)")
+	reopen()
+	db.Ping()
+	reopen()
+	p.setErr(nil)
+	p.setDelay(25 * time.Millisecond)
+	queryValue(t, db, "select 1")
+	reopen()
+	db.DisablePrimary()
+
+	want := map[string]uint64{"query_error": 1, "slow_query": 1, "manual": 1, "ping": 1, "read_only": 1}
+	if got := db.Stats().FailoverReasons; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v expected %v", got, want)
+	}
+	if n := db.Stats().TotalFailovers; n != 5 {
+		t.Fatalf("got %d failovers expected 5", n)
+	}
+}
+
+func TestEndpointErrors(t *testing.T) {
+	db, p, s := newTestDB(t)
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	queryValue(t, db, "select 1")
+	s.setErr(errTest)
+	db.Query("select 1")
+	p.setErr(nil)
+	rows, err := db.QueryContext(ForcePrimary(context.Background()), "select 1")
+	scanValue(t, rows, err)
+
+	st := db.Stats()
+	if st.PrimaryErrors != 1 || st.SecondaryErrors != 1 {
+		t.Fatalf("got %d primary errors and %d secondary errors expected 1 and 1", st.PrimaryErrors, st.SecondaryErrors)
+	}
+}
@@ -0,0 +1,104 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// stickySession pins a Secondary connection per RetryDB for WithStickySession
+type stickySession struct {
+	sync.Mutex
+	conns map[*RetryDB]*stickyConn
+	done  bool
+}
+
+// stickyConn is a connection pinned to the secondary it belongs to
+type stickyConn struct {
+	conn      *sql.Conn
+	secondary Retryable
+}
+
+// WithStickySession returns a context in which Secondary queries reuse a
+// single connection, so a Secondary behind a load balancer or proxy serves
+// them all from the same backend. Each RetryDB queried with the context pins
+// its own connection. The connections are returned to the pool when ctx is
+// done, so ctx should be cancelled when the operation ends; a ctx that is
+// never done (such as context.Background()) is returned unchanged since its
+// connections would never be released. Secondaries that do not support
+// Conn(ctx) are queried as usual.
+func WithStickySession(ctx context.Context) context.Context {
+	if ctx.Done() == nil {
+		return ctx
+	}
+	s := &stickySession{}
+	context.AfterFunc(ctx, s.release)
+	return context.WithValue(ctx, stickySessionKey, s)
+}
+
+// release closes the pinned connections, returning them to their pools
+func (s *stickySession) release() {
+	s.Lock()
+	defer s.Unlock()
+	s.done = true
+	for _, c := range s.conns {
+		c.conn.Close()
+	}
+	s.conns = nil
+}
+
+// sessionConn returns the Secondary connection db has pinned for the sticky
+// session in ctx, connecting to secondary if there is none yet, or nil when
+// there is no session
+func (db *RetryDB) sessionConn(ctx context.Context, secondary Retryable) (*sql.Conn, error) {
+	s, ok := ctx.Value(stickySessionKey).(*stickySession)
+	if !ok {
+		return nil, nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.done {
+		return nil, nil
+	}
+	if c, ok := s.conns[db]; ok && c.secondary == secondary {
+		return c.conn, nil
+	}
+	c, ok := secondary.(conner)
+	if !ok {
+		return nil, nil
+	}
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if previous, ok := s.conns[db]; ok {
+		// Close waits for open rows on the connection
+		go previous.conn.Close()
+	}
+	if s.conns == nil {
+		s.conns = make(map[*RetryDB]*stickyConn)
+	}
+	s.conns[db] = &stickyConn{conn, secondary}
+	return conn, nil
+}
+
+// sessionSecondary returns the secondary db has pinned for the sticky session
+// in ctx, or nil when there is none or it is no longer a secondary of db
+func (db *RetryDB) sessionSecondary(ctx context.Context) Retryable {
+	s, ok := ctx.Value(stickySessionKey).(*stickySession)
+	if !ok {
+		return nil
+	}
+	s.Lock()
+	c, ok := s.conns[db]
+	s.Unlock()
+	if !ok {
+		return nil
+	}
+	db.RLock()
+	defer db.RUnlock()
+	if c.secondary != db.Secondary && !containsSecondary(db.secondaries, c.secondary) {
+		return nil
+	}
+	return c.secondary
+}
@@ -0,0 +1,83 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestStickySession(t *testing.T) {
+	db, _, _ := newTestDB(t)
+	db.SetReadPreference(SecondaryOnly)
+	connIDs := func(ctx context.Context) []string {
+		t.Helper()
+		var ids []string
+		var open []*sql.Rows
+		for i := 0; i < 3; i++ {
+			rows, err := db.QueryContext(ctx, "select connection_id()")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows.Next()
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+			open = append(open, rows)
+		}
+		for _, rows := range open {
+			rows.Close()
+		}
+		return ids
+	}
+
+	if ids := connIDs(context.Background()); ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Fatalf("expected distinct connections without a session; got %v", ids)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = WithStickySession(ctx)
+	if ids := connIDs(ctx); ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Fatalf("expected one connection within a session; got %v", ids)
+	}
+	secondary := db.Secondary.(*sql.DB)
+	if n := secondary.Stats().InUse; n != 1 {
+		t.Fatalf("got %d connections in use expected 1", n)
+	}
+
+	cancel()
+	for i := 0; secondary.Stats().InUse != 0; i++ {
+		if i == 100 {
+			t.Fatal("session connection not released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStickySessionPerDB(t *testing.T) {
+	db1, _, s1 := newTestDB(t)
+	db2, _, s2 := newTestDB(t)
+	db1.SetReadPreference(SecondaryOnly)
+	db2.SetReadPreference(SecondaryOnly)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = WithStickySession(ctx)
+	for i := 0; i < 2; i++ {
+		for _, tc := range []struct {
+			db   *RetryDB
+			want string
+		}{{db1, s1.name}, {db2, s2.name}} {
+			rows, err := tc.db.QueryContext(ctx, "select 1")
+			if v := scanValue(t, rows, err); v != tc.want {
+				t.Fatalf("got %q expected %q", v, tc.want)
+			}
+		}
+	}
+
+	if WithStickySession(context.Background()) != context.Background() {
+		t.Fatal("expected no session on a context that is never done")
+	}
+}
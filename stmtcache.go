@@ -0,0 +1,158 @@
+package retrydb
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// SetStmtCacheSize caches up to n prepared statements, keyed by endpoint and
+// query, which Query and Exec reuse instead of sending the query text each
+// time. The least recently used statement is closed when the cache is full.
+// Endpoints that can not prepare statements are queried directly. Zero (the
+// default) disables the cache and closes any cached statements.
+func (db *RetryDB) SetStmtCacheSize(n int) {
+	db.Lock()
+	old := db.stmts
+	if n > 0 {
+		db.stmts = newStmtCache(n)
+	} else {
+		db.stmts = nil
+	}
+	db.Unlock()
+	if old != nil {
+		old.invalidate(nil)
+	}
+}
+
+type stmtKey struct {
+	endpoint Retryable
+	query    string
+}
+
+type stmtEntry struct {
+	key  stmtKey
+	stmt *sql.Stmt
+}
+
+// stmtCache is an LRU cache of prepared statements
+type stmtCache struct {
+	size  int
+	order *list.List // of *stmtEntry, most recently used first
+	items map[stmtKey]*list.Element
+	sync.Mutex
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[stmtKey]*list.Element),
+	}
+}
+
+// get returns a cached statement for query on endpoint, preparing it if needed
+func (c *stmtCache) get(ctx context.Context, endpoint Retryable, p preparer, query string) (*sql.Stmt, error) {
+	key := stmtKey{endpoint, query}
+	c.Lock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		c.Unlock()
+		return e.Value.(*stmtEntry).stmt, nil
+	}
+	c.Unlock()
+
+	stmt, err := p.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	if e, ok := c.items[key]; ok {
+		// prepared concurrently
+		c.order.MoveToFront(e)
+		c.Unlock()
+		stmt.Close()
+		return e.Value.(*stmtEntry).stmt, nil
+	}
+	c.items[key] = c.order.PushFront(&stmtEntry{key, stmt})
+	var evicted *sql.Stmt
+	if c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*stmtEntry).key)
+		evicted = e.Value.(*stmtEntry).stmt
+	}
+	c.Unlock()
+	if evicted != nil {
+		evicted.Close()
+	}
+	return stmt, nil
+}
+
+// invalidate closes and removes the statements for endpoint, or all
+// statements when endpoint is nil
+func (c *stmtCache) invalidate(endpoint Retryable) {
+	var closing []*sql.Stmt
+	c.Lock()
+	for e := c.order.Front(); e != nil; {
+		next := e.Next()
+		entry := e.Value.(*stmtEntry)
+		if endpoint == nil || entry.key.endpoint == endpoint {
+			c.order.Remove(e)
+			delete(c.items, entry.key)
+			closing = append(closing, entry.stmt)
+		}
+		e = next
+	}
+	c.Unlock()
+	for _, stmt := range closing {
+		stmt.Close()
+	}
+}
+
+// stmtCacheFor returns the statement cache and preparer for endpoint, or nil
+// if statements are not cached for it
+func (db *RetryDB) stmtCacheFor(endpoint Retryable) (*stmtCache, preparer) {
+	db.RLock()
+	c := db.stmts
+	db.RUnlock()
+	p, ok := endpoint.(preparer)
+	if c == nil || !ok {
+		return nil, nil
+	}
+	return c, p
+}
+
+// endpointQuery runs query against endpoint using a cached prepared
+// statement when enabled
+func (db *RetryDB) endpointQuery(ctx context.Context, endpoint Retryable, query string, args ...interface{}) (*sql.Rows, error) {
+	if c, p := db.stmtCacheFor(endpoint); c != nil {
+		// statements acquire their own connections, so all time is Exec
+		defer timeExec(ctx, time.Now())
+		stmt, err := c.get(ctx, endpoint, p, query)
+		if err != nil {
+			return nil, err
+		}
+		return stmt.QueryContext(ctx, args...)
+	}
+	return timedQueryContext(ctx, endpoint, query, args...)
+}
+
+// primaryExec runs query against the Primary using a cached prepared
+// statement when enabled
+func (db *RetryDB) primaryExec(query string, args ...interface{}) (sql.Result, error) {
+	if c, p := db.stmtCacheFor(db.Primary); c != nil {
+		stmt, err := c.get(context.Background(), db.Primary, p, query)
+		if err != nil {
+			return nil, err
+		}
+		return stmt.Exec(args...)
+	}
+	return db.Primary.Exec(query, args...)
+}
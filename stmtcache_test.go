@@ -0,0 +1,57 @@
+package retrydb
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStmtCache(t *testing.T) {
+	db, p, s := newTestDB(t)
+	db.SetStmtCacheSize(2)
+	prepares := func(f *fakeServer) int {
+		f.Lock()
+		defer f.Unlock()
+		return f.prepares
+	}
+
+	for i := 0; i < 3; i++ {
+		if v := queryValue(t, db, "select 1"); v != p.name {
+			t.Fatalf("got %q expected %q", v, p.name)
+		}
+		if _, err := db.Exec("insert into t values (?)", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := prepares(p); n != 2 {
+		t.Fatalf("got %d primary prepares expected 2", n)
+	}
+
+	// evicts the least recently used statement
+	queryValue(t, db, "select 2")
+	queryValue(t, db, "select 1")
+	if n := prepares(p); n != 4 {
+		t.Fatalf("got %d primary prepares expected 4", n)
+	}
+
+	// statements are cached per endpoint
+	p.setErr(errTest)
+	queryValue(t, db, "select 1")
+	queryValue(t, db, "select 1")
+	if n := prepares(s); n != 1 {
+		t.Fatalf("got %d secondary prepares expected 1", n)
+	}
+
+	// reconnecting invalidates the primary statements
+	p.setErr(nil)
+	if err := db.ReconnectPrimary(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	queryValue(t, db, "select 1")
+	if n := prepares(p); n != 5 {
+		t.Fatalf("got %d primary prepares expected 5", n)
+	}
+	queryValue(t, db, "select 1")
+	if n := prepares(s); n != 1 {
+		t.Fatalf("got %d secondary prepares expected 1", n)
+	}
+}
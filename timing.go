@@ -0,0 +1,68 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// QueryTiming splits the duration of a query into the time spent waiting for
+// a pooled connection and the time spent running the query. When a query
+// fails over, both attempts are included.
+type QueryTiming struct {
+	Acquire time.Duration
+	Exec    time.Duration
+	Total   time.Duration // including any failover overhead and backoff
+}
+
+// SetOnQueryTiming sets a callback invoked after every Query and QueryContext
+// like SetOnQuery, but reporting QueryTiming instead of a single duration. The
+// acquire time is only measured for endpoints that provide connections (such
+// as *sql.DB) when cached statements are not in use (see SetStmtCacheSize);
+// otherwise all time is reported as Exec. Measuring the acquire time obtains
+// a dedicated connection for each query, which is returned to the pool when
+// the Rows are closed.
+func (db *RetryDB) SetOnQueryTiming(f func(query string, source Source, t QueryTiming, err error)) {
+	db.Lock()
+	db.onQueryTiming = f
+	db.Unlock()
+}
+
+// timedQueryContext is queryContext recording acquire and exec time when ctx
+// carries a *QueryTiming
+func timedQueryContext(ctx context.Context, r Retryable, query string, args ...interface{}) (*sql.Rows, error) {
+	timing, _ := ctx.Value(queryTimingKey).(*QueryTiming)
+	if timing == nil {
+		return queryContext(ctx, r, query, args...)
+	}
+	c, ok := r.(conner)
+	if !ok {
+		start := time.Now()
+		rows, err := queryContext(ctx, r, query, args...)
+		timing.Exec += time.Since(start)
+		return rows, err
+	}
+	start := time.Now()
+	conn, err := c.Conn(ctx)
+	acquired := time.Now()
+	timing.Acquire += acquired.Sub(start)
+	if err != nil {
+		return nil, err
+	}
+	// the connection is returned to the pool once the rows are closed. Close
+	// waits for the rows so it can't run while they are closing.
+	release := func() { go conn.Close() }
+	rows, err := closingQuery(ctx, release, func(ctx context.Context) (*sql.Rows, error) {
+		return conn.QueryContext(ctx, query, args...)
+	})
+	timing.Exec += time.Since(acquired)
+	return rows, err
+}
+
+// timeExec adds the time since start to the Exec time when ctx carries a
+// *QueryTiming
+func timeExec(ctx context.Context, start time.Time) {
+	if timing, _ := ctx.Value(queryTimingKey).(*QueryTiming); timing != nil {
+		timing.Exec += time.Since(start)
+	}
+}
@@ -0,0 +1,60 @@
+package retrydb
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestOnQueryTiming(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	db.SetMaxOpenConns(1)
+	p.setDelay(5 * time.Millisecond)
+	var timing QueryTiming
+	var source Source
+	db.SetOnQueryTiming(func(query string, s Source, qt QueryTiming, err error) {
+		timing, source = qt, s
+	})
+
+	queryValue(t, db, "select 1")
+	if source != SourcePrimary || timing.Exec < 5*time.Millisecond || timing.Total < timing.Acquire+timing.Exec {
+		t.Fatalf("got %s %+v", source, timing)
+	}
+
+	// hold the only connection so the next query waits for it
+	held, err := db.Query("select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(20*time.Millisecond, func() { held.Close() })
+	queryValue(t, db, "select 1")
+	if timing.Acquire < 20*time.Millisecond || timing.Exec < 5*time.Millisecond {
+		t.Fatalf("got %+v", timing)
+	}
+}
+
+func TestOnQueryTimingStmtCache(t *testing.T) {
+	db, p, _ := newTestDB(t)
+	db.SetStmtCacheSize(2)
+	p.setDelay(5 * time.Millisecond)
+	var timing QueryTiming
+	db.SetOnQueryTiming(func(query string, s Source, qt QueryTiming, err error) {
+		timing = qt
+	})
+
+	for i := 0; i < 2; i++ {
+		queryValue(t, db, "select 1")
+		if timing.Acquire != 0 || timing.Exec < 5*time.Millisecond {
+			t.Fatalf("got %+v", timing)
+		}
+	}
+	p.Lock()
+	prepares := p.prepares
+	p.Unlock()
+	if prepares != 1 {
+		t.Fatalf("got %d prepares expected 1", prepares)
+	}
+	if n := db.Primary.(*sql.DB).Stats().InUse; n != 0 {
+		t.Fatalf("got %d connections in use", n)
+	}
+}
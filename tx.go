@@ -0,0 +1,52 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// beginTx starts a transaction on r, passing ctx and opts through when r supports it
+func beginTx(ctx context.Context, r Retryable, opts *sql.TxOptions) (*sql.Tx, error) {
+	if b, ok := r.(txBeginner); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return r.Begin()
+}
+
+// BeginTx starts a transaction against Primary. Transactions never fail
+// over, but a connection error starting one opens the failover window so
+// reads move to the Secondary.
+func (db *RetryDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := beginTx(ctx, db.Primary, opts)
+	if isConnError(err) && db.secondaryActive() {
+		// the transaction still fails, but reads move to the Secondary
+		db.updateRetry(ctx, ReasonQueryError, fmt.Errorf("begin errored with %s. retrying reads against secondary", err))
+	}
+	return tx, err
+}
+
+// InTx runs fn in a transaction against Primary. The transaction is committed
+// if fn returns nil and rolled back otherwise; the error from fn is returned
+// as is. If fn panics the transaction is rolled back and the panic re-raised.
+func (db *RetryDB) InTx(ctx context.Context, opts *sql.TxOptions, fn func(*sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,67 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestInTx(t *testing.T) {
+	db, p, s := newTestDB(t)
+	ctx := context.Background()
+
+	err := db.InTx(ctx, nil, func(tx *sql.Tx) error {
+		_, err := tx.Exec("insert into t values (1)")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.InTx(ctx, nil, func(tx *sql.Tx) error { return errTest })
+	if err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("got panic %v", r)
+			}
+		}()
+		db.InTx(ctx, nil, func(tx *sql.Tx) error { panic("boom") })
+	}()
+
+	if p.commits != 1 || p.rollbacks != 2 || p.execCount() != 1 {
+		t.Fatalf("got commits:%d rollbacks:%d execs:%d", p.commits, p.rollbacks, p.execCount())
+	}
+	if s.commits != 0 || s.rollbacks != 0 {
+		t.Fatalf("unexpected secondary transaction")
+	}
+}
+
+func TestBeginConnError(t *testing.T) {
+	db, p, s := newTestDB(t)
+	p.setErr(syscall.ECONNRESET)
+	if _, err := db.Begin(); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("got %v expected %v", err, syscall.ECONNRESET)
+	}
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if n := p.queryCount(); n != 0 {
+		t.Fatalf("got %d primary queries expected 0", n)
+	}
+
+	// other errors leave reads on the primary
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	p.setErr(errTest)
+	if _, err := db.BeginTx(context.Background(), nil); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	if n := db.Stats().RetryCount; n != 0 {
+		t.Fatalf("got retry count %d expected 0", n)
+	}
+}
@@ -0,0 +1,55 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SetSecondaryValidationQuery sets a cheap query, such as "SELECT 1", run
+// against the Secondary before the first query it serves in each failover.
+// While validation fails queries fall back to the Primary and validation is
+// retried with the next query. An empty query disables validation.
+func (db *RetryDB) SetSecondaryValidationQuery(query string) {
+	db.Lock()
+	db.validationQuery = query
+	db.validatedSecondaries = nil
+	db.Unlock()
+}
+
+// failoverQuery runs query against the Secondary during a failover, or the
+// Primary if the Secondary fails validation
+func (db *RetryDB) failoverQuery(ctx context.Context, query string, args []interface{}) (*sql.Rows, Source, error) {
+	ctx, secondary := db.withSecondary(ctx)
+	if err := db.validateSecondary(ctx, secondary); err != nil {
+		db.logger(ctx).Printf("secondary validation query failed; querying primary. %s", err)
+		rows, err := db.primaryQueryRetry(ctx, query, args...)
+		return rows, SourcePrimary, err
+	}
+	return db.secondaryQueryRetry(ctx, query, args...)
+}
+
+// validateSecondary runs the validation query against secondary unless it
+// already passed in this failover
+func (db *RetryDB) validateSecondary(ctx context.Context, secondary Retryable) error {
+	db.RLock()
+	query := db.validationQuery
+	validated := containsSecondary(db.validatedSecondaries, secondary)
+	db.RUnlock()
+	if query == "" || validated {
+		return nil
+	}
+	rows, err := queryContext(ctx, secondary, query)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	db.Lock()
+	if db.validationQuery == query {
+		db.validatedSecondaries = append(db.validatedSecondaries, secondary)
+	}
+	db.Unlock()
+	return nil
+}
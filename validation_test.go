@@ -0,0 +1,60 @@
+package retrydb
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestSecondaryValidationQuery(t *testing.T) {
+	db, p, s := newTestDB(t)
+	var buf bytes.Buffer
+	db.SetLogger(log.New(&buf, "", 0))
+	db.SetSecondaryValidationQuery("SELECT 1")
+	var validations int
+	var validationErr error
+	s.setHandler(func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if query == "SELECT 1" {
+			validations++
+			return []string{"1"}, [][]driver.Value{{int64(1)}}, validationErr
+		}
+		return []string{"source"}, [][]driver.Value{{s.name}}, nil
+	})
+
+	// failed validation falls back to the primary
+	validationErr = errTest
+	p.setErr(errTest)
+	if _, err := db.Query("select 1"); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	p.setErr(nil)
+	if v := queryValue(t, db, "select 1"); v != p.name {
+		t.Fatalf("got %q expected %q", v, p.name)
+	}
+	if !strings.Contains(buf.String(), "secondary validation query failed") {
+		t.Fatalf("got log %q", buf.String())
+	}
+
+	// validation only runs once per failover
+	validationErr = nil
+	for i := 0; i < 3; i++ {
+		if v := queryValue(t, db, "select 1"); v != s.name {
+			t.Fatalf("got %q expected %q", v, s.name)
+		}
+	}
+	if validations != 3 {
+		t.Fatalf("got %d validations expected 3", validations)
+	}
+
+	db.updateRetry(context.Background(), ReasonNone, nil)
+	p.setErr(errTest)
+	if v := queryValue(t, db, "select 1"); v != s.name {
+		t.Fatalf("got %q expected %q", v, s.name)
+	}
+	if validations != 4 {
+		t.Fatalf("got %d validations expected 4", validations)
+	}
+}
@@ -0,0 +1,75 @@
+package retrydb
+
+import (
+	"context"
+	"time"
+)
+
+// defaultVersionQuery is the query ServerVersion runs unless SetVersionQuery
+// is used
+const defaultVersionQuery = "SELECT VERSION()"
+
+// versionCacheTTL is how long ServerVersion results are reused
+const versionCacheTTL = time.Minute
+
+type serverVersions struct {
+	primary, secondary string
+	at                 time.Time
+}
+
+// SetVersionQuery sets the query ServerVersion runs against source, for
+// drivers without VERSION(); for example "select sqlite_version()". An empty
+// query restores the default "SELECT VERSION()".
+func (db *RetryDB) SetVersionQuery(source Source, query string) {
+	if source != SourcePrimary && source != SourceSecondary {
+		return
+	}
+	db.Lock()
+	db.versionQueries[source] = query
+	db.versions = serverVersions{}
+	db.Unlock()
+}
+
+// ServerVersion returns the server version reported by the Primary and the
+// Secondary (empty without a Secondary). Results are cached for a minute.
+func (db *RetryDB) ServerVersion(ctx context.Context) (primary, secondary string, err error) {
+	db.RLock()
+	cached := db.versions
+	queries := db.versionQueries
+	db.RUnlock()
+	if !cached.at.IsZero() && time.Since(cached.at) < versionCacheTTL {
+		return cached.primary, cached.secondary, nil
+	}
+
+	if primary, err = serverVersion(ctx, db.Primary, queries[SourcePrimary]); err != nil {
+		return "", "", err
+	}
+	if db.Secondary != nil {
+		if secondary, err = serverVersion(ctx, db.Secondary, queries[SourceSecondary]); err != nil {
+			return "", "", err
+		}
+	}
+	db.Lock()
+	db.versions = serverVersions{primary, secondary, time.Now()}
+	db.Unlock()
+	return primary, secondary, nil
+}
+
+func serverVersion(ctx context.Context, r Retryable, query string) (string, error) {
+	if query == "" {
+		query = defaultVersionQuery
+	}
+	rows, err := queryContext(ctx, r, query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var version string
+	if rows.Next() {
+		err = rows.Scan(&version)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	return version, err
+}
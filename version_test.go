@@ -0,0 +1,47 @@
+package retrydb
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestServerVersion(t *testing.T) {
+	db, p, s := newTestDB(t)
+	ctx := context.Background()
+	versionHandler := func(versions map[string]string) fakeHandler {
+		return func(ctx context.Context, query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+			return []string{"version"}, [][]driver.Value{{versions[query]}}, nil
+		}
+	}
+	p.setHandler(versionHandler(map[string]string{"SELECT VERSION()": "8.0.36"}))
+	s.setHandler(versionHandler(map[string]string{"select sqlite_version()": "3.45.1"}))
+	db.SetVersionQuery(SourceSecondary, "select sqlite_version()")
+
+	for i := 0; i < 2; i++ {
+		primary, secondary, err := db.ServerVersion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if primary != "8.0.36" || secondary != "3.45.1" {
+			t.Fatalf("got %q %q", primary, secondary)
+		}
+	}
+	// the second call is cached
+	if p.queryCount() != 1 || s.queryCount() != 1 {
+		t.Fatalf("got %d primary and %d secondary queries", p.queryCount(), s.queryCount())
+	}
+
+	// errors are not cached
+	db.SetVersionQuery(SourcePrimary, "")
+	p.setHandler(nil)
+	p.setErr(errTest)
+	if _, _, err := db.ServerVersion(ctx); err != errTest {
+		t.Fatalf("got %v expected %v", err, errTest)
+	}
+	p.setErr(nil)
+	p.setHandler(versionHandler(map[string]string{"SELECT VERSION()": "8.0.37"}))
+	if primary, _, err := db.ServerVersion(ctx); err != nil || primary != "8.0.37" {
+		t.Fatalf("got %q %v", primary, err)
+	}
+}